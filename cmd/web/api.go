@@ -0,0 +1,515 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"runtime/debug"
+	"sinistra/snippetbox/models"
+	"sinistra/snippetbox/pkg/forms"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The JSON API lives under /api/v1. Its handlers work in the same way as the
+// HTML ones, and use the same models and form validation, but read and write
+// JSON. Errors are sent as an APIError with the same status codes as
+// ClientError() and NotFound().
+
+// APISnippet is the JSON representation of a snippet. Expires is null for
+// snippets which never expire.
+type APISnippet struct {
+	ID         int        `json:"id"`
+	Slug       string     `json:"slug"`
+	URL        string     `json:"url"`
+	Title      string     `json:"title"`
+	Content    string     `json:"content"`
+	Language   string     `json:"language"`
+	Author     string     `json:"author,omitempty"`
+	Visibility string     `json:"visibility"`
+	Burn       bool       `json:"burn"`
+	Protected  bool       `json:"protected"`
+	Encrypted  bool       `json:"encrypted"`
+	Created    time.Time  `json:"created"`
+	Expires    *time.Time `json:"expires"`
+}
+
+// APISnippetList is a page of snippets, along with the URLs of the newer and
+// older pages, which are empty when there is no such page.
+type APISnippetList struct {
+	Snippets []*APISnippet `json:"snippets"`
+	Newer    string        `json:"newer,omitempty"`
+	Older    string        `json:"older,omitempty"`
+}
+
+// APISnippetInput holds the fields of a request to create or update a
+// snippet. They're pointers so that an update can tell which fields were
+// given. Expires is the name of an expiry preset, or "custom" with an RFC 3339
+// time in ExpiresAt, and giving just ExpiresAt implies "custom".
+type APISnippetInput struct {
+	Title          *string `json:"title"`
+	Content        *string `json:"content"`
+	Language       *string `json:"language"`
+	Expires        *string `json:"expires"`
+	ExpiresAt      *string `json:"expires_at"`
+	Visibility     *string `json:"visibility"`
+	Password       *string `json:"password"`
+	RemovePassword *bool   `json:"remove_password"`
+	Burn           *bool   `json:"burn"`
+	Encrypted      *bool   `json:"encrypted"`
+}
+
+// APIError is the body of an error response. Failures holds the validation
+// failure messages, keyed by the JSON field name.
+type APIError struct {
+	Error    string            `json:"error"`
+	Failures map[string]string `json:"failures,omitempty"`
+}
+
+// The maxAPIBody constant limits the size of request bodies.
+const maxAPIBody = 1 << 20
+
+// The WriteJSON() helper sends v as JSON with the given status code.
+func (app *App) WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		app.APIServerError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// The APIClientError helper is the JSON equivalent of ClientError().
+func (app *App) APIClientError(w http.ResponseWriter, status int) {
+	app.WriteJSON(w, status, &APIError{Error: http.StatusText(status)})
+}
+
+// The APIServerError helper is the JSON equivalent of ServerError().
+func (app *App) APIServerError(w http.ResponseWriter, err error) {
+	log.Printf("%s\n%s", err.Error(), debug.Stack())
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write([]byte(`{"error":"Internal Server Error"}` + "\n"))
+}
+
+// contextKey is the type of the keys for values which middleware adds to the
+// request context, so that they can't collide with keys from other packages.
+type contextKey string
+
+const tokenContextKey = contextKey("token")
+
+// The APIToken middleware authenticates requests which have an
+// "Authorization: Bearer" header with a personal access token, as an
+// alternative to the session cookie. Requests without the header are passed on
+// unchanged, but a token which isn't valid is rejected with a 401 Unauthorized
+// response, rather than quietly treating the request as anonymous.
+func (app *App) APIToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 2 || !strings.EqualFold(fields[0], "Bearer") {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_request"`)
+			app.APIClientError(w, http.StatusUnauthorized)
+			return
+		}
+		token, err := app.Database.GetTokenByHash(models.HashToken(fields[1]))
+		if err != nil {
+			app.APIServerError(w, err)
+			return
+		}
+		if token == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			app.APIClientError(w, http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(r.Context(), tokenContextKey, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// The RequestToken() helper returns the token which APIToken authenticated the
+// request with, or nil if it didn't have one.
+func RequestToken(r *http.Request) *models.Token {
+	token, _ := r.Context().Value(tokenContextKey).(*models.Token)
+	return token
+}
+
+// The APIRequireLogin middleware is the JSON equivalent of RequireLogin().
+// There's no login page to redirect to, so it sends a 401 Unauthorized
+// response instead. Requests authenticated with a token are allowed if the
+// token has the scope, and get a 403 Forbidden response if it doesn't. It
+// must be used inside APIToken.
+func (app *App) APIRequireLogin(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := RequestToken(r); token != nil {
+			if !token.Allows(scope) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+				app.APIClientError(w, http.StatusForbidden)
+				return
+			}
+		} else if app.CurrentUserID(r) == 0 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.APIClientError(w, http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The apiSnippet() function converts a snippet to its JSON representation.
+func apiSnippet(r *http.Request, s *models.Snippet) *APISnippet {
+	a := &APISnippet{
+		ID:         s.ID,
+		Slug:       s.Slug,
+		URL:        requestOrigin(r) + s.Path(),
+		Title:      s.Title,
+		Content:    s.Content,
+		Language:   s.Language,
+		Author:     s.Author,
+		Visibility: s.Visibility,
+		Burn:       s.Burn,
+		Protected:  s.Protected(),
+		Encrypted:  s.Encrypted,
+		Created:    s.Created,
+	}
+	if !s.NeverExpires() {
+		a.Expires = &s.Expires
+	}
+	return a
+}
+
+// The requestOrigin() function returns the scheme and host that the request
+// was made to, for building absolute URLs.
+func requestOrigin(r *http.Request) string {
+	if r.TLS != nil {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
+
+// The APISnippet() helper fetches the snippet identified by the ":id" URL
+// parameter, which may be either its slug or its numeric ID. It sends a 404
+// Not Found response and returns nil if the snippet doesn't exist or the
+// current user isn't allowed to see it, or a 410 Gone if it was a burn after
+// reading snippet which has been viewed, in the same way as
+// RequestedSnippet().
+func (app *App) APISnippet(w http.ResponseWriter, r *http.Request) *models.Snippet {
+	id := r.URL.Query().Get(":id")
+	var snippet *models.Snippet
+	var err error
+	if n, convErr := strconv.Atoi(id); convErr == nil {
+		snippet, err = app.Database.GetSnippet(n, app.CurrentUserID(r))
+	} else {
+		snippet, err = app.Database.GetSnippetBySlug(id, app.CurrentUserID(r))
+	}
+	if err != nil {
+		app.APIServerError(w, err)
+		return nil
+	}
+	if snippet == nil {
+		burned, err := app.Database.SnippetBurned(id)
+		if err != nil {
+			app.APIServerError(w, err)
+		} else if burned {
+			app.APIClientError(w, http.StatusGone)
+		} else {
+			app.APIClientError(w, http.StatusNotFound)
+		}
+		return nil
+	}
+	return snippet
+}
+
+// The APIOwnedSnippet() helper is the JSON equivalent of OwnedSnippet().
+func (app *App) APIOwnedSnippet(w http.ResponseWriter, r *http.Request) *models.Snippet {
+	snippet := app.APISnippet(w, r)
+	if snippet == nil {
+		return nil
+	}
+	if !app.IsOwner(r, snippet) {
+		app.APIClientError(w, http.StatusForbidden)
+		return nil
+	}
+	return snippet
+}
+
+// The DecodeJSON() helper decodes a JSON request body into v. It sends a 415
+// Unsupported Media Type response if the body isn't JSON, or a 400 Bad
+// Request if it can't be decoded, and returns false. Requiring the JSON
+// content type also means that browsers won't send cross-site requests
+// without asking first, so the API doesn't need CSRF tokens.
+func (app *App) DecodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		app.APIClientError(w, http.StatusUnsupportedMediaType)
+		return false
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		app.WriteJSON(w, http.StatusBadRequest, &APIError{Error: "Invalid JSON: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+// The apply() method copies the fields given in the input onto the form.
+// ExpiresAt is converted from RFC 3339 to the form's layout, and the returned
+// failures hold any that can't be.
+func (in *APISnippetInput) apply(form *forms.NewSnippet) map[string]string {
+	failures := map[string]string{}
+	setString(&form.Title, in.Title)
+	setString(&form.Content, in.Content)
+	setString(&form.Language, in.Language)
+	setString(&form.Visibility, in.Visibility)
+	setString(&form.Password, in.Password)
+	setBool(&form.RemovePassword, in.RemovePassword)
+	setBool(&form.Burn, in.Burn)
+	setBool(&form.Encrypted, in.Encrypted)
+	if in.Expires != nil {
+		form.Expires = *in.Expires
+		form.KeepExpires = false
+	}
+	if in.ExpiresAt != nil {
+		if in.Expires == nil {
+			form.Expires = "custom"
+			form.KeepExpires = false
+		}
+		t, err := time.Parse(time.RFC3339, *in.ExpiresAt)
+		if err != nil {
+			failures["expires_at"] = "Expiry date must be an RFC 3339 date and time"
+		} else {
+			form.ExpiresAt = t.UTC().Format(forms.DateTimeLayout)
+		}
+	}
+	return failures
+}
+
+func setString(dst *string, src *string) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+func setBool(dst *bool, src *bool) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+// The ValidationFailed() helper sends a 422 Unprocessable Entity response
+// holding the form's failure messages, keyed by the JSON field names.
+func (app *App) ValidationFailed(w http.ResponseWriter, failures ...map[string]string) {
+	body := &APIError{Error: "Validation failed", Failures: map[string]string{}}
+	for _, f := range failures {
+		for field, message := range f {
+			body.Failures[jsonField(field)] = message
+		}
+	}
+	app.WriteJSON(w, http.StatusUnprocessableEntity, body)
+}
+
+// The jsonField() function converts a form field name, such as "Visibility",
+// to the name of the JSON field, such as "visibility".
+func jsonField(field string) string {
+	switch field {
+	case "ExpiresAt":
+		return "expires_at"
+	case "RemovePassword":
+		return "remove_password"
+	}
+	return strings.ToLower(field)
+}
+
+// The APIListSnippets handler returns a page of the latest public snippets,
+// using the same cursors as the home page.
+func (app *App) APIListSnippets(w http.ResponseWriter, r *http.Request) {
+	snippets, page, err := app.SnippetPage(r.URL.Query(), models.SnippetFilter{})
+	if err == ErrBadCursor {
+		app.APIClientError(w, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		app.APIServerError(w, err)
+		return
+	}
+
+	list := &APISnippetList{Snippets: []*APISnippet{}}
+	for _, s := range snippets {
+		list.Snippets = append(list.Snippets, apiSnippet(r, s))
+	}
+	if page.Newer != "" {
+		list.Newer = requestOrigin(r) + r.URL.Path + page.Newer
+	}
+	if page.Older != "" {
+		list.Older = requestOrigin(r) + r.URL.Path + page.Older
+	}
+	app.WriteJSON(w, http.StatusOK, list)
+}
+
+// The APIShowSnippet handler returns a single snippet. As with the raw
+// endpoint, a password-protected snippet has to have been unlocked, and a
+// burn after reading snippet is burned, unless the request is a HEAD request.
+func (app *App) APIShowSnippet(w http.ResponseWriter, r *http.Request) {
+	snippet := app.APISnippet(w, r)
+	if snippet == nil {
+		return
+	}
+	if !app.Unlocked(r, snippet) {
+		app.APIClientError(w, http.StatusForbidden)
+		return
+	}
+	if snippet.Burn {
+		w.Header().Set("Cache-Control", "no-store")
+		if !app.IsOwner(r, snippet) && r.Method != http.MethodHead {
+			burned, err := app.Database.BurnSnippet(snippet)
+			if err != nil {
+				app.APIServerError(w, err)
+				return
+			}
+			if !burned {
+				app.APIClientError(w, http.StatusGone)
+				return
+			}
+		}
+	}
+	app.WriteJSON(w, http.StatusOK, apiSnippet(r, snippet))
+}
+
+// The APICreateSnippet handler creates a snippet owned by the current user.
+// Fields which aren't given take the same defaults as the new snippet form.
+// It responds with 201 Created and the new snippet.
+func (app *App) APICreateSnippet(w http.ResponseWriter, r *http.Request) {
+	var in APISnippetInput
+	if !app.DecodeJSON(w, r, &in) {
+		return
+	}
+	form := &forms.NewSnippet{
+		Expires:    app.Expiry.Default,
+		Visibility: models.Public,
+		Policy:     app.Expiry,
+	}
+	failures := in.apply(form)
+	if !form.Valid() || len(failures) > 0 {
+		app.ValidationFailed(w, form.Failures, failures)
+		return
+	}
+
+	snippet := &models.Snippet{
+		UserID:     app.CurrentUserID(r),
+		Title:      form.Title,
+		Content:    form.Content,
+		Expires:    ExpiresAt(form),
+		Burn:       form.Burn,
+		Visibility: form.Visibility,
+		Encrypted:  form.Encrypted,
+		Language:   form.Language,
+	}
+	if err := snippet.SetPassword(form.Password); err != nil {
+		app.APIServerError(w, err)
+		return
+	}
+	if err := app.Database.InsertSnippet(snippet); err != nil {
+		app.APIServerError(w, err)
+		return
+	}
+	// Fetch the snippet back, so that the response includes the fields set by
+	// the database, such as the creation time and author.
+	stored, err := app.Database.GetSnippet(snippet.ID, snippet.UserID)
+	if err != nil {
+		app.APIServerError(w, err)
+		return
+	}
+	if stored != nil {
+		snippet = stored
+	}
+	w.Header().Set("Location", requestOrigin(r)+"/api/v1/snippets/"+snippet.Slug)
+	app.WriteJSON(w, http.StatusCreated, apiSnippet(r, snippet))
+}
+
+// The APIUpdateSnippet handler changes the given fields of one of the current
+// user's snippets, leaving the others as they are, and responds with the
+// updated snippet. Snippets can't be changed to or from being burn after
+// reading or encrypted, and the content of an encrypted snippet can't be
+// changed.
+func (app *App) APIUpdateSnippet(w http.ResponseWriter, r *http.Request) {
+	snippet := app.APIOwnedSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+	var in APISnippetInput
+	if !app.DecodeJSON(w, r, &in) {
+		return
+	}
+	failures := map[string]string{}
+	if in.Burn != nil && *in.Burn != snippet.Burn {
+		failures["burn"] = "Burn after reading can't be changed"
+	}
+	if in.Encrypted != nil && *in.Encrypted != snippet.Encrypted {
+		failures["encrypted"] = "Encryption can't be changed"
+	}
+	if snippet.Encrypted && in.Content != nil && *in.Content != snippet.Content {
+		failures["content"] = "The content of an encrypted snippet can't be changed"
+	}
+
+	form := &forms.NewSnippet{
+		Title:       snippet.Title,
+		Content:     snippet.Content,
+		Burn:        snippet.Burn,
+		Visibility:  snippet.Visibility,
+		Encrypted:   snippet.Encrypted,
+		Language:    snippet.Language,
+		KeepExpires: true,
+		Policy:      app.Expiry,
+	}
+	for field, message := range in.apply(form) {
+		failures[field] = message
+	}
+	if !form.Valid() || len(failures) > 0 {
+		app.ValidationFailed(w, form.Failures, failures)
+		return
+	}
+
+	snippet.Title = form.Title
+	snippet.Content = form.Content
+	snippet.Visibility = form.Visibility
+	snippet.Language = form.Language
+	if !form.KeepExpires {
+		snippet.Expires = ExpiresAt(form)
+	}
+	if form.RemovePassword {
+		snippet.SetPassword("")
+	} else if form.Password != "" {
+		if err := snippet.SetPassword(form.Password); err != nil {
+			app.APIServerError(w, err)
+			return
+		}
+	}
+	if err := app.Database.UpdateSnippet(snippet); err != nil {
+		app.APIServerError(w, err)
+		return
+	}
+	app.WriteJSON(w, http.StatusOK, apiSnippet(r, snippet))
+}
+
+// The APIDeleteSnippet handler deletes one of the current user's snippets,
+// and responds with 204 No Content.
+func (app *App) APIDeleteSnippet(w http.ResponseWriter, r *http.Request) {
+	snippet := app.APIOwnedSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+	if err := app.Database.DeleteSnippet(snippet.ID); err != nil {
+		app.APIServerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
-	"github.com/alexedwards/scs"
+	"github.com/alexedwards/scs/v2"
 	"sinistra/snippetbox/models"
+	"sinistra/snippetbox/pkg/forms"
+	"sinistra/snippetbox/pkg/ratelimit"
+	"time"
 )
 
 // Define an App struct to hold the application-wide dependencies and configuration
@@ -11,11 +14,19 @@ import (
 // Add a new StaticDir field to our application dependencies.
 
 type App struct {
-	Addr      string // Add an Addr field
-	Database  *models.Database
-	HTMLDir   string
-	Sessions  *scs.SessionManager
-	StaticDir string
-	TLSCert   string // Add a TLSCert field
-	TLSKey    string // Add a TLSKey field
+	Addr            string             // Add an Addr field
+	AnonUploadLimit *ratelimit.Limiter // Raw uploads per client without a token
+	BurnedRetention time.Duration      // How long to remember burned snippets; zero keeps them forever
+	Database        models.Store
+	Expiry          *forms.ExpiryPolicy // The expiry times offered for new snippets (the defaults if nil)
+	HTMLDir         string
+	ReapBatchSize   int           // Maximum number of expired snippets or burned tombstones deleted per statement
+	ReapGrace       time.Duration // How long after expiry a snippet is kept before deletion
+	ReapInterval    time.Duration // How often to delete expired snippets; zero disables it
+	Sessions        *scs.SessionManager
+	StaticDir       string
+	TLSCert         string             // Add a TLSCert field
+	TLSKey          string             // Add a TLSKey field
+	UnlockLimit     *ratelimit.Limiter // Failed snippet password attempts per client and snippet
+	UploadLimit     *ratelimit.Limiter // Raw uploads per user with a token
 }
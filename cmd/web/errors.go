@@ -28,3 +28,10 @@ func (app *App) ClientError(w http.ResponseWriter, status int) {
 func (app *App) NotFound(w http.ResponseWriter) {
 	app.ClientError(w, http.StatusNotFound)
 }
+
+// The Burned helper sends a 410 Gone response with a page explaining that the
+// requested burn after reading snippet has already been viewed and destroyed.
+func (app *App) Burned(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	app.RenderHTMLStatus(w, r, http.StatusGone, "burned.page.html", nil)
+}
@@ -1,63 +1,307 @@
 package main
 
 import (
-	"context"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"sinistra/snippetbox/models"
+	"sinistra/snippetbox/pkg/diff"
 	"sinistra/snippetbox/pkg/forms"
+	"sinistra/snippetbox/pkg/markdown"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Change the signature of our Home handler so it is defined as a method against *App.
 func (app *App) Home(w http.ResponseWriter, r *http.Request) {
-	// Fetch a slice of the latest snippets from the database.
-	snippets, err := app.Database.LatestSnippets()
-	if err != nil {
-		app.ServerError(w, err)
+	// Fetch a page of the latest snippets from the database, starting from the
+	// ?before= or ?after= cursor if there is one.
+	snippets, page := app.PagedSnippets(w, r, models.SnippetFilter{})
+	if page == nil {
 		return
 	}
 	// Pass the slice of snippets to the "home.page.html" templates.
 	app.RenderHTML(w, r, "home.page.html", &HTMLData{
+		Page:     page,
 		Snippets: snippets,
 	})
 
 }
 
-// Change the signature of our ShowSnippet handler so it is defined as a method // against App.
-func (app *App) ShowSnippet(w http.ResponseWriter, r *http.Request) {
-	// Pat doesn't strip the colon from the named capture key, so we need to
-	// get the value of ":id" from the query string instead of "id".
-	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
-	if err != nil || id < 1 {
-		app.NotFound(w) // Use the app.NotFound() helper.
+// The Archive handler pages through all the unexpired snippets, optionally
+// restricted to those created between the ?from= and ?to= dates (inclusive).
+func (app *App) Archive(w http.ResponseWriter, r *http.Request) {
+	form := &forms.ArchiveFilter{
+		From: r.URL.Query().Get("from"),
+		To:   r.URL.Query().Get("to"),
+	}
+	if !form.Valid() {
+		app.RenderHTML(w, r, "archive.page.html", &HTMLData{Form: form})
+		return
+	}
+
+	filter := models.SnippetFilter{}
+	if form.From != "" {
+		filter.From, _ = time.Parse(forms.DateLayout, form.From)
+	}
+	if form.To != "" {
+		to, _ := time.Parse(forms.DateLayout, form.To)
+		filter.To = to.AddDate(0, 0, 1)
+	}
+	snippets, page := app.PagedSnippets(w, r, filter)
+	if page == nil {
+		return
+	}
+	app.RenderHTML(w, r, "archive.page.html", &HTMLData{
+		Form:     form,
+		Page:     page,
+		Snippets: snippets,
+	})
+}
+
+// The Search handler shows the snippets matching the ?q= query string
+// parameter, most relevant first, ten to a page.
+func (app *App) Search(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	data := &SearchData{Query: query}
+	if query == "" {
+		app.RenderHTML(w, r, "search.page.html", &HTMLData{Search: data})
 		return
 	}
 
-	snippet, err := app.Database.GetSnippet(id)
+	snippets, more, err := app.Database.SearchSnippets(query, page)
 	if err != nil {
 		app.ServerError(w, err)
 		return
 	}
+	if page > 1 {
+		data.Prev = fmt.Sprintf("?q=%s&page=%d", url.QueryEscape(query), page-1)
+	}
+	if more {
+		data.Next = fmt.Sprintf("?q=%s&page=%d", url.QueryEscape(query), page+1)
+	}
+	app.RenderHTML(w, r, "search.page.html", &HTMLData{
+		Search:   data,
+		Snippets: snippets,
+	})
+}
+
+// Change the signature of our ShowSnippet handler so it is defined as a method // against App.
+func (app *App) ShowSnippet(w http.ResponseWriter, r *http.Request) {
+	// The RequestedSnippet() helper takes care of looking up the ":slug"
+	// parameter and sending a 404 response if the snippet doesn't exist.
+	snippet := app.RequestedSnippet(w, r)
 	if snippet == nil {
-		app.NotFound(w)
 		return
 	}
 
-	// Load the session data then use the PopString() method to retrieve the value
-	// for the "flash" key. PopString() also deletes the key and value from the
-	// session data, so it acts like a one-time fetch. If there is no matching
+	// Password-protected snippets show a form asking for the password instead
+	// of the content, until the password has been entered. This comes before
+	// the burn after reading check, so that looking at the form doesn't burn
+	// the snippet.
+	if !app.Unlocked(r, snippet) {
+		w.Header().Set("Cache-Control", "no-store")
+		app.RenderHTML(w, r, "unlock.page.html", &HTMLData{
+			Snippet: snippet,
+			Form:    &forms.UnlockSnippet{},
+		})
+		return
+	}
+
+	// Burn after reading snippets are deleted the first time they're viewed by
+	// anyone other than their owner.
+	if !app.BurnAfterReading(w, r, snippet) {
+		return
+	}
+
+	// Use the PopString() method to retrieve the value for the "flash" key from
+	// the session data, which the LoadAndSave middleware loaded for this
+	// request. PopString() also deletes the key and value from the session
+	// data, so it acts like a one-time fetch. If there is no matching
 	// key in the session data it will return the empty string. If you want to
 	// retrieve a string from the session and not delete it you should use the
 	// GetString() method instead.
-	session, _ := app.Sessions.Load(r.Context(), "flash")
-	flash := app.Sessions.PopString(session, "flash")
+	flash := app.Sessions.PopString(r.Context(), "flash")
 	//io.WriteString(w, flash)
 
-	// Render the show.page.html template, passing in the snippet data wrapped in our HTMLData struct.
-	app.RenderHTML(w, r, "show.page.html", &HTMLData{
+	// Render Markdown snippets as HTML, and highlight the content of any
+	// others, unless it was encrypted in the browser, in which case the server
+	// only has the ciphertext.
+	data := &HTMLData{
 		Snippet: snippet,
 		Flash:   flash,
+	}
+	switch {
+	case snippet.Encrypted:
+		// The page's script decrypts and displays the content.
+	case snippet.Language == "markdown":
+		data.Markdown = markdown.Render(snippet.Content)
+	default:
+		var err error
+		data.Code, err = highlightCode(snippet.Content, snippet.Language)
+		if err != nil {
+			app.ServerError(w, err)
+			return
+		}
+	}
+
+	// Render the show.page.html template, passing in the snippet data wrapped in our HTMLData struct.
+	app.RenderHTML(w, r, "show.page.html", data)
+}
+
+// The RawSnippet handler sends the content of a snippet as plain text, so that
+// scripts and curl users don't have to scrape it from the HTML page. The
+// content of an encrypted snippet is the ciphertext, as it's stored.
+func (app *App) RawSnippet(w http.ResponseWriter, r *http.Request) {
+	snippet := app.ContentSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+	app.ServeContent(w, r, snippet)
+}
+
+// The DownloadSnippet handler works like RawSnippet, but asks the browser to
+// save the content as a file named after the snippet's title and language.
+func (app *App) DownloadSnippet(w http.ResponseWriter, r *http.Request) {
+	snippet := app.ContentSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": DownloadFilename(snippet)})
+	w.Header().Set("Content-Disposition", disposition)
+	app.ServeContent(w, r, snippet)
+}
+
+// The UnlockSnippet handler checks the password submitted for a
+// password-protected snippet. Attempts are counted for each client and
+// snippet, and once there have been too many without the right password the
+// client has to wait before trying again, so that the password can't be
+// guessed by brute force.
+func (app *App) UnlockSnippet(w http.ResponseWriter, r *http.Request) {
+	snippet := app.RequestedSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+	if app.Unlocked(r, snippet) {
+		http.Redirect(w, r, snippet.Path(), http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	form := &forms.UnlockSnippet{
+		Password: r.PostForm.Get("password"),
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if !form.Valid() {
+		app.RenderHTML(w, r, "unlock.page.html", &HTMLData{Snippet: snippet, Form: form})
+		return
+	}
+
+	// Count the attempt before checking the password, rather than after it
+	// fails, so that guesses made in parallel can't all get past the limit
+	// while bcrypt is running. The count is reset when the password is right.
+	key := ClientIP(r) + " " + snippet.Slug
+	if !app.UnlockLimit.Take(key) {
+		form.Failures["Generic"] = "Too many incorrect passwords. Please try again later."
+		app.RenderHTMLStatus(w, r, http.StatusTooManyRequests, "unlock.page.html", &HTMLData{Snippet: snippet, Form: form})
+		return
+	}
+	err = snippet.CheckPassword(form.Password)
+	if err == models.ErrInvalidCredentials {
+		form.Failures["Generic"] = "Password is incorrect"
+		app.RenderHTML(w, r, "unlock.page.html", &HTMLData{Snippet: snippet, Form: form})
+		return
+	} else if err != nil {
+		app.ServerError(w, err)
+		return
+	}
+
+	app.UnlockLimit.Reset(key)
+	app.Unlock(r, snippet)
+	http.Redirect(w, r, snippet.Path(), http.StatusSeeOther)
+}
+
+// The SnippetHistory handler lists every revision of a snippet, with a form
+// for choosing two revisions to compare.
+func (app *App) SnippetHistory(w http.ResponseWriter, r *http.Request) {
+	snippet := app.HistorySnippet(w, r)
+	if snippet == nil {
+		return
+	}
+	revisions, err := app.Database.SnippetRevisions(snippet.ID)
+	if err != nil {
+		app.ServerError(w, err)
+		return
+	}
+	app.RenderHTML(w, r, "history.page.html", &HTMLData{
+		Snippet:   snippet,
+		Revisions: revisions,
+	})
+}
+
+func (app *App) ShowRevision(w http.ResponseWriter, r *http.Request) {
+	snippet := app.HistorySnippet(w, r)
+	if snippet == nil {
+		return
+	}
+	revision := app.RequestedRevision(w, snippet.ID, r.URL.Query().Get(":n"))
+	if revision == nil {
+		return
+	}
+	code, err := highlightCode(revision.Content, snippet.Language)
+	if err != nil {
+		app.ServerError(w, err)
+		return
+	}
+	app.RenderHTML(w, r, "revision.page.html", &HTMLData{
+		Code:     code,
+		Snippet:  snippet,
+		Revision: revision,
+	})
+}
+
+// The DiffSnippet handler shows a unified diff between the revisions given in
+// the "from" and "to" query string parameters.
+func (app *App) DiffSnippet(w http.ResponseWriter, r *http.Request) {
+	snippet := app.HistorySnippet(w, r)
+	if snippet == nil {
+		return
+	}
+	from := app.RequestedRevision(w, snippet.ID, r.URL.Query().Get("from"))
+	if from == nil {
+		return
+	}
+	to := app.RequestedRevision(w, snippet.ID, r.URL.Query().Get("to"))
+	if to == nil {
+		return
+	}
+	// Revisions which are too large or too different to compare line by line
+	// are still shown as differing, without the details.
+	hunks, err := diff.Unified(from.Content, to.Content, 3)
+	if err != nil && err != diff.ErrTooLarge {
+		app.ServerError(w, err)
+		return
+	}
+	app.RenderHTML(w, r, "diff.page.html", &HTMLData{
+		Snippet: snippet,
+		Diff: &DiffData{
+			From:     from,
+			To:       to,
+			Hunks:    hunks,
+			TooLarge: err == diff.ErrTooLarge,
+		},
 	})
 }
 
@@ -73,9 +317,16 @@ func (app *App) CreateSnippet(w http.ResponseWriter, r *http.Request) {
 	// We initialize a *forms.NewSnippet object and use the r.PostForm.Get() method
 	// to assign the data to the relevant fields.
 	form := &forms.NewSnippet{
-		Title:   r.PostForm.Get("title"),
-		Content: r.PostForm.Get("content"),
-		Expires: r.PostForm.Get("expires"),
+		Title:      r.PostForm.Get("title"),
+		Content:    r.PostForm.Get("content"),
+		Expires:    r.PostForm.Get("expires"),
+		ExpiresAt:  r.PostForm.Get("expires_at"),
+		Burn:       r.PostForm.Get("burn") == "true",
+		Visibility: r.PostForm.Get("visibility"),
+		Password:   r.PostForm.Get("password"),
+		Encrypted:  r.PostForm.Get("encrypted") == "true",
+		Language:   r.PostForm.Get("language"),
+		Policy:     app.Expiry,
 	}
 	// Check if the form passes the validation checks. If not, then use the
 	// fmt.Fprint function to dump the failure messages to the response body.
@@ -87,25 +338,58 @@ func (app *App) CreateSnippet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// If the validation checks have been passed, call our database model's
-	// InsertSnippet() method to create a new database record and return it's ID value.
-	id, err := app.Database.InsertSnippet(form.Title, form.Content, form.Expires)
+	// InsertSnippet() method to create a new database record owned by the
+	// current user. This fills in the snippet's ID (and its slug if it's
+	// unlisted) so that we know where to redirect to.
+	snippet := &models.Snippet{
+		UserID:     app.CurrentUserID(r),
+		Title:      form.Title,
+		Content:    form.Content,
+		Expires:    ExpiresAt(form),
+		Burn:       form.Burn,
+		Visibility: form.Visibility,
+		Encrypted:  form.Encrypted,
+		Language:   form.Language,
+	}
+	if err = snippet.SetPassword(form.Password); err != nil {
+		app.ServerError(w, err)
+		return
+	}
+	err = app.Database.InsertSnippet(snippet)
 	if err != nil {
 		app.ServerError(w, err)
 		return
 	}
 
-	// Use session manager's Load() method to fetch the session data for the current
-	// request. If there's no existing session for the current user (or their
-	// session has expired) then a new, empty, session will be created. Any errors
-	// are deferred until the session is actually used.
-	session, _ := app.Sessions.Load(r.Context(), "flash")
-	// Use the PutString() method to add a string value ("Your snippet was saved
+	// The LoadAndSave middleware has already loaded the session data for the
+	// current request. If there's no existing session for the current user (or
+	// their session has expired) then a new, empty, session was created, and
+	// it's saved once the handler returns.
+	// Use the Put() method to add a string value ("Your snippet was saved
 	// successfully!") and the corresponding key ("flash") to the the session data.
-	app.Sessions.Put(session, "flash", "Your snippet was saved successfully!")
+	if snippet.Encrypted {
+		app.Sessions.Put(r.Context(), "flash", "Your snippet was encrypted and saved. Share the whole link, including the key after the #, so that people can read it.")
+	} else {
+		app.Sessions.Put(r.Context(), "flash", "Your snippet was saved successfully!")
+	}
 
 	// If successful, send a 303 See Other response redirecting the user to the
 	// page with their new snippet.
-	http.Redirect(w, r, fmt.Sprintf("/snippet/%d", id), http.StatusSeeOther)
+	http.Redirect(w, r, snippet.Path(), http.StatusSeeOther)
+}
+
+// The PreviewSnippet handler renders the Markdown in the "content" form field
+// for the preview tab on the new snippet form. The response is just the
+// sanitized HTML, which the page's script puts in place.
+func (app *App) PreviewSnippet(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	io.WriteString(w, string(markdown.Render(r.PostForm.Get("content"))))
 }
 
 func (app *App) NewSnippet(w http.ResponseWriter, r *http.Request) {
@@ -113,10 +397,201 @@ func (app *App) NewSnippet(w http.ResponseWriter, r *http.Request) {
 	// Because it's empty, it won't contain any previously submitted data or validation
 	// failure messages.
 	app.RenderHTML(w, r, "new.page.html", &HTMLData{
-		Form: &forms.NewSnippet{},
+		Form: &forms.NewSnippet{Policy: app.Expiry},
+	})
+}
+
+// The UserSnippets handler lists the snippets created by the current user.
+func (app *App) UserSnippets(w http.ResponseWriter, r *http.Request) {
+	snippets, err := app.Database.UserSnippets(app.CurrentUserID(r))
+	if err != nil {
+		app.ServerError(w, err)
+		return
+	}
+
+	flash := app.Sessions.PopString(r.Context(), "flash")
+
+	app.RenderHTML(w, r, "mysnippets.page.html", &HTMLData{
+		Flash:    flash,
+		Snippets: snippets,
+	})
+}
+
+func (app *App) EditSnippet(w http.ResponseWriter, r *http.Request) {
+	snippet := app.OwnedSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+	// Pre-fill the form with the current title, content and expiry time. If
+	// custom expiry dates aren't allowed the default preset is selected
+	// instead, counted from the time of the edit.
+	form := &forms.NewSnippet{
+		Title:      snippet.Title,
+		Content:    snippet.Content,
+		Visibility: snippet.Visibility,
+		Language:   snippet.Language,
+		Policy:     app.Expiry,
+	}
+	if _, ok := app.Expiry.Preset("never"); ok && snippet.NeverExpires() {
+		form.Expires = "never"
+	} else if app.Expiry.AllowCustom && !snippet.NeverExpires() {
+		form.Expires = "custom"
+		form.ExpiresAt = snippet.Expires.Format(forms.DateTimeLayout)
+	}
+	app.RenderHTML(w, r, "edit.page.html", &HTMLData{
+		Snippet: snippet,
+		Form:    form,
+	})
+}
+
+func (app *App) UpdateSnippet(w http.ResponseWriter, r *http.Request) {
+	snippet := app.OwnedSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	// Edits are validated in exactly the same way as new snippets.
+	form := &forms.NewSnippet{
+		Title:          r.PostForm.Get("title"),
+		Content:        r.PostForm.Get("content"),
+		Expires:        r.PostForm.Get("expires"),
+		ExpiresAt:      r.PostForm.Get("expires_at"),
+		Visibility:     r.PostForm.Get("visibility"),
+		Password:       r.PostForm.Get("password"),
+		RemovePassword: r.PostForm.Get("remove_password") == "true",
+		Language:       r.PostForm.Get("language"),
+		Policy:         app.Expiry,
+	}
+	// The server can't decrypt an encrypted snippet's content, so the edit
+	// form doesn't include it, and the ciphertext is kept as it is.
+	if snippet.Encrypted {
+		form.Content = snippet.Content
+		form.Encrypted = true
+	}
+	if !form.Valid() {
+		app.RenderHTML(w, r, "edit.page.html", &HTMLData{Snippet: snippet, Form: form})
+		return
+	}
+
+	snippet.Title = form.Title
+	snippet.Content = form.Content
+	snippet.Expires = ExpiresAt(form)
+	snippet.Visibility = form.Visibility
+	snippet.Language = form.Language
+	// A blank password keeps the snippet's current password, if it has one.
+	if form.RemovePassword {
+		snippet.SetPassword("")
+	} else if form.Password != "" {
+		if err = snippet.SetPassword(form.Password); err != nil {
+			app.ServerError(w, err)
+			return
+		}
+	}
+	err = app.Database.UpdateSnippet(snippet)
+	if err != nil {
+		app.ServerError(w, err)
+		return
+	}
+
+	app.Sessions.Put(r.Context(), "flash", "Your snippet was updated successfully!")
+	http.Redirect(w, r, snippet.Path(), http.StatusSeeOther)
+}
+
+func (app *App) DeleteSnippet(w http.ResponseWriter, r *http.Request) {
+	snippet := app.OwnedSnippet(w, r)
+	if snippet == nil {
+		return
+	}
+
+	err := app.Database.DeleteSnippet(snippet.ID)
+	if err != nil {
+		app.ServerError(w, err)
+		return
+	}
+
+	app.Sessions.Put(r.Context(), "flash", "Your snippet was deleted.")
+	http.Redirect(w, r, "/user/snippets", http.StatusSeeOther)
+}
+
+// The Account handler lists the current user's personal access tokens, with
+// a form for creating new ones.
+func (app *App) Account(w http.ResponseWriter, r *http.Request) {
+	app.RenderAccount(w, r, &forms.NewToken{Scope: models.ScopeRead}, "")
+}
+
+// The RenderAccount() helper renders the account page. The token is only
+// passed in straight after it's been created, as it can't be shown again.
+func (app *App) RenderAccount(w http.ResponseWriter, r *http.Request, form *forms.NewToken, token string) {
+	tokens, err := app.Database.UserTokens(app.CurrentUserID(r))
+	if err != nil {
+		app.ServerError(w, err)
+		return
+	}
+
+	flash := app.Sessions.PopString(r.Context(), "flash")
+
+	app.RenderHTML(w, r, "account.page.html", &HTMLData{
+		Flash:  flash,
+		Form:   form,
+		Token:  token,
+		Tokens: tokens,
 	})
 }
 
+func (app *App) CreateToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := &forms.NewToken{
+		Name:  r.PostForm.Get("name"),
+		Scope: r.PostForm.Get("scope"),
+	}
+	if !form.Valid() {
+		app.RenderAccount(w, r, form, "")
+		return
+	}
+
+	token, t, err := models.NewToken(app.CurrentUserID(r), form.Name, form.Scope)
+	if err != nil {
+		app.ServerError(w, err)
+		return
+	}
+	err = app.Database.InsertToken(t)
+	if err != nil {
+		app.ServerError(w, err)
+		return
+	}
+
+	// The token isn't stored anywhere, so rather than redirecting we render
+	// the page with it straight away. It's never put in the session.
+	app.RenderAccount(w, r, &forms.NewToken{Scope: models.ScopeRead}, token)
+}
+
+func (app *App) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.NotFound(w)
+		return
+	}
+
+	err = app.Database.DeleteToken(id, app.CurrentUserID(r))
+	if err != nil {
+		app.ServerError(w, err)
+		return
+	}
+
+	app.Sessions.Put(r.Context(), "flash", "Your token was revoked.")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
 func (app *App) SignupUser(w http.ResponseWriter, r *http.Request) {
 	app.RenderHTML(w, r, "signup.page.html", &HTMLData{
 		Form: &forms.SignupUser{},
@@ -140,8 +615,6 @@ func (app *App) CreateUser(w http.ResponseWriter, r *http.Request) {
 		app.RenderHTML(w, r, "signup.page.html", &HTMLData{Form: form})
 		return
 	}
-	fmt.Fprintln(w, "Create a new user...")
-
 	// Try to create a new user record in the database. If the email already exists
 	// add a failure message to the form and re-display the form.
 	err = app.Database.InsertUser(form.Name, form.Email, form.Password)
@@ -155,24 +628,13 @@ func (app *App) CreateUser(w http.ResponseWriter, r *http.Request) {
 	}
 	// Otherwise, add a confirmation flash message to the session confirming that
 	// their signup worked and asking them to log in.
-	msg := "Your signup was successful. Please log in using your credentials."
-	session, err := app.Sessions.Load(context.Background(), "user")
-	err := session.Value("flash", msg)
-	if err != nil {
-		app.ServerError(w, err)
-		return
-	}
+	app.Sessions.Put(r.Context(), "flash", "Your signup was successful. Please log in using your credentials.")
 	// And redirect the user to the login page.
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
 func (app *App) LoginUser(w http.ResponseWriter, r *http.Request) {
-	session := app.Sessions.Load(r)
-	flash, err := session.PopString(w, "flash")
-	if err != nil {
-		app.ServerError(w, err)
-		return
-	}
+	flash := app.Sessions.PopString(r.Context(), "flash")
 	app.RenderHTML(w, r, "login.page.html", &HTMLData{Flash: flash,
 		Form: &forms.LoginUser{}})
 }
@@ -204,25 +666,28 @@ func (app *App) VerifyUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Add the ID of the current user to the session, so that they are now 'logged // in'.
-	session := app.Sessions.Load(r)
-	err = session.PutInt(w, "currentUserID", currentUserID)
+	// Renew the session token whenever the privilege level changes, so that
+	// a session ID planted before logging in can't be used to hijack the
+	// session afterwards. Then add the ID of the current user to the session,
+	// so that they are now 'logged in'.
+	err = app.Sessions.RenewToken(r.Context())
 	if err != nil {
 		app.ServerError(w, err)
 		return
 	}
+	app.Sessions.Put(r.Context(), "userID", currentUserID)
 	// Redirect the user to the Add Snippet page.
 	http.Redirect(w, r, "/snippet/new", http.StatusSeeOther)
 }
 
 func (app *App) LogoutUser(w http.ResponseWriter, r *http.Request) {
-	// Remove the currentUserID from the session data.
-	session := app.Sessions.Load(r)
-	err := session.Remove(w, "currentUserID")
+	// Renew the session token, and remove the userID from the session data.
+	err := app.Sessions.RenewToken(r.Context())
 	if err != nil {
 		app.ServerError(w, err)
 		return
 	}
+	app.Sessions.Remove(r.Context(), "userID")
 	// Redirect the user to the homepage.
 	http.Redirect(w, r, "/", 303)
 }
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"fmt"
+	"github.com/alexedwards/scs/v2"
+	"html"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sinistra/snippetbox/models"
+	"sinistra/snippetbox/pkg/forms"
+	"sinistra/snippetbox/pkg/ratelimit"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rxCSRFToken matches the hidden CSRF token field in the HTML forms.
+var rxCSRFToken = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// rxSnippetRow matches a link to a snippet in the home page table.
+var rxSnippetRow = regexp.MustCompile(`<td><a href="/snippet/`)
+
+// testServer is a TLS test server running the application's routes against a
+// MemoryStore, with a client which keeps cookies and doesn't follow
+// redirects. The session and CSRF cookies are Secure, hence the TLS.
+type testServer struct {
+	*httptest.Server
+	t      *testing.T
+	store  *models.MemoryStore
+	client *http.Client
+}
+
+// The newTestServer() helper starts a testServer with a user called Alice,
+// whose ID is 1, and stops it when the test finishes.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	store := models.NewMemoryStore()
+	if err := store.InsertUser("Alice", "alice@example.com", "password123"); err != nil {
+		t.Fatal(err)
+	}
+	sessions := scs.New()
+	sessions.Cookie.Secure = true
+	app := &App{
+		AnonUploadLimit: ratelimit.New(2, time.Minute),
+		Database:        store,
+		HTMLDir:         "../../ui/html",
+		Sessions:        sessions,
+		StaticDir:       "../../ui/static",
+		UnlockLimit:     ratelimit.New(3, time.Minute),
+		UploadLimit:     ratelimit.New(5, time.Hour),
+	}
+
+	ts := &testServer{Server: httptest.NewTLSServer(app.Routes()), t: t, store: store}
+	t.Cleanup(ts.Close)
+	ts.client = ts.Client()
+	ts.client.Jar, _ = cookiejar.New(nil)
+	ts.client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return ts
+}
+
+// The get() method fetches a path, and returns the response and its body.
+func (ts *testServer) get(path string) (*http.Response, string) {
+	ts.t.Helper()
+	res, err := ts.client.Get(ts.URL + path)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	return res, string(body)
+}
+
+// The head() method sends a HEAD request for a path, as link checkers and
+// curl -I do, and returns the response.
+func (ts *testServer) head(path string) *http.Response {
+	ts.t.Helper()
+	res, err := ts.client.Head(ts.URL + path)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	res.Body.Close()
+	return res
+}
+
+// The postForm() method posts a form to a path, with a Referer header as
+// nosurf requires for HTTPS requests, and returns the response.
+func (ts *testServer) postForm(path string, form url.Values) *http.Response {
+	ts.t.Helper()
+	req, err := http.NewRequest("POST", ts.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Referer", ts.URL+path)
+	res, err := ts.client.Do(req)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	res.Body.Close()
+	return res
+}
+
+// The csrfToken() method returns the CSRF token from the form on a page.
+func (ts *testServer) csrfToken(path string) string {
+	ts.t.Helper()
+	_, body := ts.get(path)
+	m := rxCSRFToken.FindStringSubmatch(body)
+	if m == nil {
+		ts.t.Fatalf("no CSRF token on %s", path)
+	}
+	return html.UnescapeString(m[1])
+}
+
+// The login() method logs in as Alice.
+func (ts *testServer) login() {
+	ts.t.Helper()
+	res := ts.postForm("/user/login", url.Values{
+		"email":      {"alice@example.com"},
+		"password":   {"password123"},
+		"csrf_token": {ts.csrfToken("/user/login")},
+	})
+	if res.StatusCode != http.StatusSeeOther {
+		ts.t.Fatalf("logging in returned %d; want %d", res.StatusCode, http.StatusSeeOther)
+	}
+}
+
+// The forgetCookies() method starts a new session, as a different visitor.
+func (ts *testServer) forgetCookies() {
+	ts.client.Jar, _ = cookiejar.New(nil)
+}
+
+// The insert() method stores a snippet directly in the MemoryStore.
+func (ts *testServer) insert(s *models.Snippet) *models.Snippet {
+	ts.t.Helper()
+	if s.Title == "" {
+		s.Title = "An old silent pond"
+	}
+	if s.Content == "" {
+		s.Content = "An old silent pond..."
+	}
+	if s.Expires.IsZero() {
+		s.Expires = time.Now().UTC().Add(time.Hour)
+	}
+	if s.Visibility == "" {
+		s.Visibility = models.Public
+	}
+	if err := ts.store.InsertSnippet(s); err != nil {
+		ts.t.Fatal(err)
+	}
+	return s
+}
+
+func TestCreateSnippet(t *testing.T) {
+	ts := newTestServer(t)
+
+	// Anonymous visitors are sent to the login page.
+	res := ts.postForm("/snippet/new", url.Values{})
+	if res.StatusCode != http.StatusFound && res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("anonymous create returned %d; want a redirect to log in", res.StatusCode)
+	}
+
+	ts.login()
+	res = ts.postForm("/snippet/new", url.Values{
+		"title":      {"Over the wintry forest"},
+		"content":    {"Over the wintry forest, winds howl in rage"},
+		"expires":    {"1d"},
+		"visibility": {models.Public},
+	})
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("create returned %d; want %d", res.StatusCode, http.StatusSeeOther)
+	}
+	location := res.Header.Get("Location")
+	if !strings.HasPrefix(location, "/snippet/") {
+		t.Fatalf("create redirected to %q; want a snippet page", location)
+	}
+
+	res, body := ts.get(location)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("showing the new snippet returned %d; want %d", res.StatusCode, http.StatusOK)
+	}
+	for _, want := range []string{"Over the wintry forest", "winds howl in rage", "Your snippet was saved successfully!"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("snippet page doesn't contain %q", want)
+		}
+	}
+
+	mine, _ := ts.store.UserSnippets(1)
+	if len(mine) != 1 {
+		t.Errorf("Alice has %d snippets; want 1", len(mine))
+	}
+}
+
+func TestCreateSnippetInvalid(t *testing.T) {
+	ts := newTestServer(t)
+	ts.login()
+
+	res := ts.postForm("/snippet/new", url.Values{
+		"title":      {""},
+		"content":    {"No title"},
+		"expires":    {"1d"},
+		"visibility": {models.Public},
+	})
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("invalid create returned %d; want the form again", res.StatusCode)
+	}
+	if latest, _ := ts.store.LatestSnippets(); len(latest) != 0 {
+		t.Errorf("invalid create stored %d snippets", len(latest))
+	}
+}
+
+func TestUserSnippets(t *testing.T) {
+	ts := newTestServer(t)
+	mine := ts.insert(&models.Snippet{UserID: 1, Title: "Mine"})
+	ts.insert(&models.Snippet{UserID: 2, Title: "Theirs"})
+	ts.insert(&models.Snippet{Title: "Anonymous"})
+
+	if res, _ := ts.get("/user/snippets"); res.StatusCode != http.StatusFound {
+		t.Errorf("anonymous GET /user/snippets returned %d; want a redirect to log in", res.StatusCode)
+	}
+
+	ts.login()
+	res, body := ts.get("/user/snippets")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET /user/snippets returned %d; want %d", res.StatusCode, http.StatusOK)
+	}
+	if !strings.Contains(body, `href="`+mine.Path()+`"`) {
+		t.Error("My snippets doesn't list Alice's snippet")
+	}
+	for _, title := range []string{"Theirs", "Anonymous"} {
+		if strings.Contains(body, title) {
+			t.Errorf("My snippets lists the snippet %q", title)
+		}
+	}
+}
+
+func TestEditSnippet(t *testing.T) {
+	// The test server's App has no expiry policy, so the edit page and update
+	// use the defaults.
+	ts := newTestServer(t)
+	s := ts.insert(&models.Snippet{UserID: 1, Title: "Old title", Content: "old content", Visibility: models.Public})
+	ts.login()
+
+	path := s.Path() + "/edit"
+	res, body := ts.get(path)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("edit page returned %d; want %d", res.StatusCode, http.StatusOK)
+	}
+	if !strings.Contains(body, "Old title") {
+		t.Error("edit page doesn't contain the snippet's title")
+	}
+
+	form := url.Values{
+		"title":      {"New title"},
+		"content":    {"new content"},
+		"expires":    {"1d"},
+		"visibility": {models.Public},
+	}
+	// Without the CSRF token the update is refused.
+	if res := ts.postForm(path, form); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("update without a CSRF token returned %d; want %d", res.StatusCode, http.StatusBadRequest)
+	}
+	form.Set("csrf_token", ts.csrfToken(path))
+	if res := ts.postForm(path, form); res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("update returned %d; want %d", res.StatusCode, http.StatusSeeOther)
+	}
+	got, _ := ts.store.GetSnippet(s.ID, 1)
+	if got == nil || got.Title != "New title" || got.Content != "new content" {
+		t.Errorf("snippet after update = %+v; want the new title and content", got)
+	}
+
+	// Other users' snippets can't be edited.
+	theirs := ts.insert(&models.Snippet{UserID: 2})
+	if res, _ := ts.get(theirs.Path() + "/edit"); res.StatusCode != http.StatusForbidden {
+		t.Errorf("editing another user's snippet returned %d; want %d", res.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestDeleteSnippet(t *testing.T) {
+	ts := newTestServer(t)
+	mine := ts.insert(&models.Snippet{UserID: 1})
+	theirs := ts.insert(&models.Snippet{UserID: 2})
+	ts.login()
+
+	token := ts.csrfToken(mine.Path())
+	res := ts.postForm(theirs.Path()+"/delete", url.Values{"csrf_token": {token}})
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("deleting another user's snippet returned %d; want %d", res.StatusCode, http.StatusForbidden)
+	}
+	if s, _ := ts.store.GetSnippet(theirs.ID, 0); s == nil {
+		t.Error("another user's snippet was deleted")
+	}
+
+	res = ts.postForm(mine.Path()+"/delete", url.Values{"csrf_token": {token}})
+	if res.StatusCode != http.StatusSeeOther || res.Header.Get("Location") != "/user/snippets" {
+		t.Fatalf("delete returned %d to %q; want %d to /user/snippets", res.StatusCode, res.Header.Get("Location"), http.StatusSeeOther)
+	}
+	if s, _ := ts.store.GetSnippet(mine.ID, 0); s != nil {
+		t.Error("the snippet wasn't deleted")
+	}
+	if _, body := ts.get("/user/snippets"); !strings.Contains(body, "Your snippet was deleted.") {
+		t.Error("My snippets doesn't confirm the deletion")
+	}
+}
+
+func TestShowSnippet(t *testing.T) {
+	ts := newTestServer(t)
+	public := ts.insert(&models.Snippet{Content: "public content"})
+	unlisted := ts.insert(&models.Snippet{Content: "unlisted content", Visibility: models.Unlisted})
+	private := ts.insert(&models.Snippet{UserID: 1, Content: "private content", Visibility: models.Private})
+	expired := ts.insert(&models.Snippet{Content: "expired content", Expires: time.Now().UTC().Add(-time.Minute)})
+
+	tests := []struct {
+		name     string
+		path     string
+		loggedIn bool
+		status   int
+		content  string
+	}{
+		{"public", public.Path(), false, http.StatusOK, "public content"},
+		{"unlisted", unlisted.Path(), false, http.StatusOK, "unlisted content"},
+		{"private", private.Path(), false, http.StatusNotFound, ""},
+		{"private to its owner", private.Path(), true, http.StatusOK, "private content"},
+		{"expired", expired.Path(), false, http.StatusNotFound, ""},
+		{"unknown slug", "/snippet/abcdefghij", false, http.StatusNotFound, ""},
+		{"old numeric URL", "/snippet/1", false, http.StatusMovedPermanently, ""},
+		{"numeric URL of an unlisted snippet", "/snippet/2", false, http.StatusNotFound, ""},
+		{"numeric pastebin raw URL", "/raw/1", false, http.StatusMovedPermanently, ""},
+		{"pastebin raw URL", "/raw/" + public.Slug, false, http.StatusOK, "public content"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.loggedIn {
+				ts.login()
+				defer ts.forgetCookies()
+			}
+			res, body := ts.get(tt.path)
+			if res.StatusCode != tt.status {
+				t.Fatalf("GET %s returned %d; want %d", tt.path, res.StatusCode, tt.status)
+			}
+			if tt.content != "" && !strings.Contains(body, tt.content) {
+				t.Errorf("GET %s doesn't contain %q", tt.path, tt.content)
+			}
+		})
+	}
+}
+
+func TestUnlockSnippet(t *testing.T) {
+	ts := newTestServer(t)
+	protected := func() *models.Snippet {
+		s := &models.Snippet{Content: "behind a password"}
+		if err := s.SetPassword("hunter22"); err != nil {
+			t.Fatal(err)
+		}
+		return ts.insert(s)
+	}
+	unlock := func(s *models.Snippet, password string) (*http.Response, string) {
+		t.Helper()
+		res := ts.postForm(s.Path()+"/unlock", url.Values{
+			"password":   {password},
+			"csrf_token": {ts.csrfToken(s.Path())},
+		})
+		_, body := ts.get(s.Path())
+		return res, body
+	}
+
+	s := protected()
+	if _, body := ts.get(s.Path()); strings.Contains(body, "behind a password") {
+		t.Fatal("the content is shown before the password is entered")
+	}
+	res, body := unlock(s, "hunter22")
+	if res.StatusCode != http.StatusSeeOther || !strings.Contains(body, "behind a password") {
+		t.Fatalf("unlocking with the password returned %d; want %d and then the content", res.StatusCode, http.StatusSeeOther)
+	}
+
+	// The test server allows three attempts, and after that even the right
+	// password is refused until the window ends.
+	s = protected()
+	for i := 1; i <= 3; i++ {
+		if res, _ := unlock(s, "wrong"); res.StatusCode != http.StatusOK {
+			t.Fatalf("wrong password %d returned %d; want %d", i, res.StatusCode, http.StatusOK)
+		}
+	}
+	for _, password := range []string{"wrong", "hunter22"} {
+		res, body := unlock(s, password)
+		if res.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("attempt over the limit with %q returned %d; want %d", password, res.StatusCode, http.StatusTooManyRequests)
+		}
+		if strings.Contains(body, "behind a password") {
+			t.Errorf("attempt over the limit with %q unlocked the snippet", password)
+		}
+	}
+}
+
+func TestSnippetHistory(t *testing.T) {
+	ts := newTestServer(t)
+	s := ts.insert(&models.Snippet{UserID: 1, Content: "a\nb\nc\n", Visibility: models.Private})
+	update := func(content string) {
+		t.Helper()
+		s.Content = content
+		if err := ts.store.UpdateSnippet(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	update("a\nx\nc\n")
+	// The third revision changes more lines than diff.MaxEdits allows.
+	update(strings.Repeat("y\n", 2001))
+	path := s.Path()
+
+	// A private snippet's history is as hidden as the snippet itself.
+	for _, p := range []string{"/history", "/rev/1", "/diff?from=1&to=2"} {
+		if res, _ := ts.get(path + p); res.StatusCode != http.StatusNotFound {
+			t.Errorf("anonymous GET %s returned %d; want %d", path+p, res.StatusCode, http.StatusNotFound)
+		}
+	}
+
+	ts.login()
+	res, body := ts.get(path + "/history")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s/history returned %d; want %d", path, res.StatusCode, http.StatusOK)
+	}
+	for n := 1; n <= 3; n++ {
+		if link := fmt.Sprintf(`href="%s/rev/%d"`, path, n); !strings.Contains(body, link) {
+			t.Errorf("history doesn't link to revision %d", n)
+		}
+	}
+
+	if res, body := ts.get(path + "/rev/1"); res.StatusCode != http.StatusOK || !strings.Contains(body, ">b</span>") {
+		t.Errorf("GET %s/rev/1 returned %d; want %d with the first revision", path, res.StatusCode, http.StatusOK)
+	}
+
+	res, body = ts.get(path + "/diff?from=1&to=2")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s/diff returned %d; want %d", path, res.StatusCode, http.StatusOK)
+	}
+	// html/template escapes the + signs.
+	body = html.UnescapeString(body)
+	for _, want := range []string{"@@ -1,3 +1,3 @@", `<span class="diff-delete">-b</span>`, `<span class="diff-insert">+x</span>`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("diff doesn't contain %q", want)
+		}
+	}
+	if _, body := ts.get(path + "/diff?from=2&to=3"); !strings.Contains(body, "too large or too different") {
+		t.Error("diff of very different revisions doesn't say it's too large to show")
+	}
+
+	for _, p := range []string{
+		"/rev/0", "/rev/4", "/rev/x",
+		"/diff", "/diff?from=1", "/diff?to=2", "/diff?from=0&to=2", "/diff?from=1&to=4", "/diff?from=x&to=2",
+	} {
+		if res, _ := ts.get(path + p); res.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s returned %d; want %d", path+p, res.StatusCode, http.StatusNotFound)
+		}
+	}
+}
+
+func TestBurnAfterReading(t *testing.T) {
+	ts := newTestServer(t)
+	s := ts.insert(&models.Snippet{UserID: 1, Content: "burn this", Burn: true, Visibility: models.Unlisted})
+
+	res, body := ts.get(s.Path())
+	if res.StatusCode != http.StatusOK || !strings.Contains(body, "burn this") {
+		t.Fatalf("first view returned %d; want %d with the content", res.StatusCode, http.StatusOK)
+	}
+	if res.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("first view has Cache-Control %q; want no-store", res.Header.Get("Cache-Control"))
+	}
+
+	res, body = ts.get(s.Path())
+	if res.StatusCode != http.StatusGone {
+		t.Fatalf("second view returned %d; want %d", res.StatusCode, http.StatusGone)
+	}
+	if strings.Contains(body, "burn this") {
+		t.Error("second view shows the content")
+	}
+}
+
+func TestBurnAfterReadingOwner(t *testing.T) {
+	ts := newTestServer(t)
+	s := ts.insert(&models.Snippet{UserID: 1, Content: "burn this", Burn: true, Visibility: models.Unlisted})
+
+	// The owner can look at their snippet without burning it.
+	ts.login()
+	for i := 0; i < 2; i++ {
+		if res, _ := ts.get(s.Path()); res.StatusCode != http.StatusOK {
+			t.Fatalf("owner's view %d returned %d; want %d", i+1, res.StatusCode, http.StatusOK)
+		}
+	}
+	if burned, _ := ts.store.SnippetBurned(s.Slug); burned {
+		t.Error("the owner burned their own snippet")
+	}
+}
+
+func TestBurnAfterReadingHead(t *testing.T) {
+	ts := newTestServer(t)
+	s := ts.insert(&models.Snippet{Content: "burn this", Burn: true, Visibility: models.Unlisted})
+
+	// A HEAD request, such as a link preview, doesn't burn the snippet, so a
+	// person can still read it afterwards.
+	if res := ts.head(s.Path()); res.StatusCode != http.StatusOK {
+		t.Fatalf("HEAD returned %d; want %d", res.StatusCode, http.StatusOK)
+	}
+	if burned, _ := ts.store.SnippetBurned(s.Slug); burned {
+		t.Fatal("a HEAD request burned the snippet")
+	}
+	if res, body := ts.get(s.Path()); res.StatusCode != http.StatusOK || !strings.Contains(body, "burn this") {
+		t.Errorf("view after HEAD returned %d; want %d with the content", res.StatusCode, http.StatusOK)
+	}
+}
+
+func TestRawSnippetHead(t *testing.T) {
+	ts := newTestServer(t)
+	for _, suffix := range []string{"/raw", "/download", ""} {
+		s := ts.insert(&models.Snippet{Content: "burn this", Burn: true, Visibility: models.Unlisted})
+		path := s.Path() + suffix
+		if suffix == "" {
+			path = "/raw/" + s.Slug
+		}
+
+		// curl -I doesn't burn the snippet.
+		res := ts.head(path)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("HEAD %s returned %d; want %d", path, res.StatusCode, http.StatusOK)
+		}
+		if res.Header.Get("Cache-Control") != "no-store" {
+			t.Errorf("HEAD %s has Cache-Control %q; want no-store", path, res.Header.Get("Cache-Control"))
+		}
+		if res, body := ts.get(path); res.StatusCode != http.StatusOK || body != "burn this" {
+			t.Errorf("GET %s after HEAD returned %d; want %d with the content", path, res.StatusCode, http.StatusOK)
+		}
+		if res, _ := ts.get(path); res.StatusCode != http.StatusGone {
+			t.Errorf("second GET %s returned %d; want %d", path, res.StatusCode, http.StatusGone)
+		}
+	}
+}
+
+func TestHomePagination(t *testing.T) {
+	ts := newTestServer(t)
+	for i := 0; i < 15; i++ {
+		ts.insert(&models.Snippet{})
+	}
+
+	res, body := ts.get("/")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET / returned %d; want %d", res.StatusCode, http.StatusOK)
+	}
+	if n := len(rxSnippetRow.FindAllString(body, -1)); n != 10 {
+		t.Errorf("first page lists %d snippets; want 10", n)
+	}
+	m := regexp.MustCompile(`<a href="(\?before=[^"]+)">Older`).FindStringSubmatch(body)
+	if m == nil {
+		t.Fatal("first page has no link to older snippets")
+	}
+	if strings.Contains(body, "Newer") {
+		t.Error("first page has a link to newer snippets")
+	}
+
+	res, body = ts.get("/" + html.UnescapeString(m[1]))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("second page returned %d; want %d", res.StatusCode, http.StatusOK)
+	}
+	if n := len(rxSnippetRow.FindAllString(body, -1)); n != 5 {
+		t.Errorf("second page lists %d snippets; want 5", n)
+	}
+	if !strings.Contains(body, "Newer") || strings.Contains(body, "Older") {
+		t.Error("second page should only link to newer snippets")
+	}
+
+}
+
+func TestArchivePagination(t *testing.T) {
+	ts := newTestServer(t)
+	for i := 0; i < 12; i++ {
+		ts.insert(&models.Snippet{})
+	}
+	today := time.Now().UTC().Format(forms.DateLayout)
+	rxNewer := regexp.MustCompile(`<a href="(\?[^"]+)">&larr; Newer`)
+	rxOlder := regexp.MustCompile(`<a href="(\?[^"]+)">Older &rarr;`)
+
+	// The page links keep the date filter along with the cursor.
+	page := func(query string, rows int, newer, older bool) (string, string) {
+		t.Helper()
+		res, body := ts.get("/snippets" + html.UnescapeString(query))
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("GET /snippets%s returned %d; want %d", query, res.StatusCode, http.StatusOK)
+		}
+		if n := len(rxSnippetRow.FindAllString(body, -1)); n != rows {
+			t.Errorf("GET /snippets%s lists %d snippets; want %d", query, n, rows)
+		}
+		var links [2]string
+		for i, link := range []struct {
+			name string
+			rx   *regexp.Regexp
+			want bool
+		}{{"newer", rxNewer, newer}, {"older", rxOlder, older}} {
+			m := link.rx.FindStringSubmatch(body)
+			if (m != nil) != link.want {
+				t.Fatalf("GET /snippets%s has a link to %s snippets %t; want %t", query, link.name, m != nil, link.want)
+			}
+			if m == nil {
+				continue
+			}
+			links[i] = m[1]
+			if q := html.UnescapeString(m[1]); !strings.Contains(q, "from="+today) || !strings.Contains(q, "to="+today) {
+				t.Errorf("page link %q doesn't keep the date filter", q)
+			}
+		}
+		return links[0], links[1]
+	}
+
+	_, older := page("?from="+today+"&to="+today, 10, false, true)
+	newer, _ := page(older, 2, true, false)
+	page(newer, 10, false, true)
+
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(forms.DateLayout)
+	if _, body := ts.get("/snippets?to=" + yesterday); !strings.Contains(body, "No snippets match.") {
+		t.Error("snippets created today were listed before today")
+	}
+}
+
+func TestBadCursor(t *testing.T) {
+	ts := newTestServer(t)
+	ts.insert(&models.Snippet{})
+
+	// A cursor is "<created nanos>-<id>"; anything else, including cursors
+	// which have been tampered with, is a client error.
+	for _, path := range []string{"/", "/snippets", "/api/v1/snippets"} {
+		for _, param := range []string{"before", "after"} {
+			for _, cursor := range []string{"nonsense", "1570438800000000000", "1570438800000000000-0", "x-1", "99999999999999999999-1"} {
+				url := path + "?" + param + "=" + cursor
+				if res, _ := ts.get(url); res.StatusCode != http.StatusBadRequest {
+					t.Errorf("GET %s returned %d; want %d", url, res.StatusCode, http.StatusBadRequest)
+				}
+			}
+		}
+	}
+}
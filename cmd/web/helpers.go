@@ -1,17 +1,413 @@
 package main
 
 import (
-	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
 	"net/http"
+	"net/url"
+	"sinistra/snippetbox/models"
+	"sinistra/snippetbox/pkg/forms"
+	"sinistra/snippetbox/pkg/syntax"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 )
 
 func (app *App) LoggedIn(r *http.Request) bool {
-	// Load the session data for the current request, and use the Exists() method // to check if it contains a currentUserID key. This returns true if the
-	// key is in the session data; false otherwise.
-	session, _ := app.Sessions.Load(context.Background(), "user")
-	loggedIn := session.Value("currentUserID")
-	if loggedIn == "" {
+	// Use the Exists() method to check if the session data for the current
+	// request contains a userID key. This returns true if the key is in the
+	// session data; false otherwise.
+	return app.Sessions.Exists(r.Context(), "userID")
+}
+
+// The CurrentUserID() helper returns the ID of the logged in user from the
+// session data, or 0 if nobody is logged in. API requests authenticated with a
+// personal access token act as the token's owner instead.
+func (app *App) CurrentUserID(r *http.Request) int {
+	if token := RequestToken(r); token != nil {
+		return token.UserID
+	}
+	return app.Sessions.GetInt(r.Context(), "userID")
+}
+
+// The IsOwner() helper reports whether the current user created the snippet.
+// Snippets without an owner don't belong to anybody, including users who
+// aren't logged in.
+func (app *App) IsOwner(r *http.Request, snippet *models.Snippet) bool {
+	return snippet.UserID != 0 && snippet.UserID == app.CurrentUserID(r)
+}
+
+// The RequestedSnippet() helper fetches the snippet identified by the ":slug"
+// URL parameter. If the snippet doesn't exist, or the current user isn't
+// allowed to see it, it sends a 404 Not Found response (or a 500 if something
+// went wrong) and returns nil. We don't send a 403 Forbidden for private
+// snippets, so that their existence isn't given away.
+//
+// Snippets used to be identified by their sequential ID, so a numeric ":slug"
+// is looked up by ID instead and the request is redirected to the same page
+// under the snippet's slug.
+func (app *App) RequestedSnippet(w http.ResponseWriter, r *http.Request) *models.Snippet {
+	// Pat doesn't strip the colon from the named capture key, so we need to
+	// get the value of ":slug" from the query string instead of "slug".
+	slug := r.URL.Query().Get(":slug")
+	if id, err := strconv.Atoi(slug); err == nil {
+		app.RedirectToSlug(w, r, id)
+		return nil
+	}
+
+	snippet, err := app.Database.GetSnippetBySlug(slug, app.CurrentUserID(r))
+	if err != nil {
+		app.ServerError(w, err)
+		return nil
+	}
+	if snippet == nil {
+		// If the snippet was a burn after reading snippet which has already
+		// been viewed, say so rather than sending a plain 404.
+		burned, err := app.Database.SnippetBurned(slug)
+		if err != nil {
+			app.ServerError(w, err)
+		} else if burned {
+			app.Burned(w, r)
+		} else {
+			app.NotFound(w)
+		}
+		return nil
+	}
+	return snippet
+}
+
+// The RedirectToSlug() helper sends a 301 Moved Permanently response
+// redirecting an old numeric snippet URL, such as /snippet/123/history or
+// /raw/123, to the same page under the snippet's slug. Only the snippets which
+// could be viewed by ID before slugs existed are redirected, so that the IDs of
+// unlisted snippets can't be used to discover their slugs.
+func (app *App) RedirectToSlug(w http.ResponseWriter, r *http.Request, id int) {
+	if id < 1 {
+		app.NotFound(w)
+		return
+	}
+	snippet, err := app.Database.GetSnippet(id, app.CurrentUserID(r))
+	if err != nil {
+		app.ServerError(w, err)
+		return
+	}
+	if snippet == nil {
+		app.NotFound(w)
+		return
+	}
+	target := snippet.Path() + strings.TrimPrefix(r.URL.Path, "/snippet/"+r.URL.Query().Get(":slug"))
+	// The pastebin.com style /raw/123 URLs go to the snippet's raw content.
+	if strings.HasPrefix(r.URL.Path, "/raw/") {
+		target = snippet.Path() + "/raw"
+	}
+	// Pat adds the named parameters to the query string, so we strip those
+	// before passing on the rest of it.
+	q := r.URL.Query()
+	for key := range q {
+		if strings.HasPrefix(key, ":") {
+			q.Del(key)
+		}
+	}
+	if len(q) > 0 {
+		target += "?" + q.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+}
+
+// The HistorySnippet() helper works like RequestedSnippet(), for the pages
+// showing a snippet's revisions. Those pages would reveal the content of a
+// burn after reading snippet without burning it, so they're only available
+// to the snippet's owner. Visitors who haven't unlocked a password-protected
+// snippet are redirected to its page to enter the password. The content
+// of an encrypted snippet never changes, so it has no history worth showing.
+func (app *App) HistorySnippet(w http.ResponseWriter, r *http.Request) *models.Snippet {
+	snippet := app.RequestedSnippet(w, r)
+	if snippet == nil {
+		return nil
+	}
+	if snippet.Encrypted || (snippet.Burn && !app.IsOwner(r, snippet)) {
+		app.NotFound(w)
+		return nil
+	}
+	if !app.Unlocked(r, snippet) {
+		http.Redirect(w, r, snippet.Path(), http.StatusSeeOther)
+		return nil
+	}
+	return snippet
+}
+
+// The BurnAfterReading() helper deletes a burn after reading snippet when it's
+// viewed by anyone other than its owner, before its content is sent. If
+// another request burned the snippet between us fetching it and trying to
+// burn it, then this viewer is too late and gets the same 410 Gone page as
+// any later visitor. It returns false if it sent a response itself.
+//
+// Pat's Get() also answers HEAD requests, which link checkers and chat apps
+// fetching link previews send without anyone reading the content, so those
+// never burn the snippet.
+func (app *App) BurnAfterReading(w http.ResponseWriter, r *http.Request, snippet *models.Snippet) bool {
+	if !snippet.Burn {
+		return true
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if app.IsOwner(r, snippet) || r.Method == http.MethodHead {
+		return true
+	}
+	burned, err := app.Database.BurnSnippet(snippet)
+	if err != nil {
+		app.ServerError(w, err)
+		return false
+	}
+	if !burned {
+		app.Burned(w, r)
 		return false
 	}
 	return true
 }
+
+// The ContentSnippet() helper fetches the snippet for the raw and download
+// endpoints. Clients which haven't unlocked a password-protected snippet get
+// a 403 Forbidden response, as there's no form to show them, and burn after
+// reading snippets are burned just as if they'd been viewed, except by HEAD
+// requests such as curl -I.
+func (app *App) ContentSnippet(w http.ResponseWriter, r *http.Request) *models.Snippet {
+	snippet := app.RequestedSnippet(w, r)
+	if snippet == nil {
+		return nil
+	}
+	if !app.Unlocked(r, snippet) {
+		w.Header().Set("Cache-Control", "no-store")
+		app.ClientError(w, http.StatusForbidden)
+		return nil
+	}
+	if !app.BurnAfterReading(w, r, snippet) {
+		return nil
+	}
+	return snippet
+}
+
+// The rawMaxAge constant is the longest time for which the content of a
+// public snippet may be cached. It's kept short because snippets can be
+// edited and deleted.
+const rawMaxAge = 5 * time.Minute
+
+// The ServeContent() helper sends a snippet's content as plain text. Public
+// snippets may be cached by anyone until shortly before they expire, and
+// other snippets only by the client, which has to check that its copy is
+// still current using the ETag. Burn after reading and password-protected
+// snippets are never cached. The Content-Security-Policy stops the content
+// being treated as anything but text if it's opened in a browser.
+func (app *App) ServeContent(w http.ResponseWriter, r *http.Request, snippet *models.Snippet) {
+	h := w.Header()
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	switch {
+	case snippet.Burn || snippet.Protected():
+		h.Set("Cache-Control", "no-store")
+	case snippet.Visibility == models.Public:
+		maxAge := rawMaxAge
+		if !snippet.NeverExpires() {
+			if untilExpiry := time.Until(snippet.Expires); untilExpiry < maxAge {
+				maxAge = untilExpiry
+			}
+			if maxAge < 0 {
+				maxAge = 0
+			}
+		}
+		h.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(maxAge.Seconds())))
+	default:
+		h.Set("Cache-Control", "private, no-cache")
+	}
+	if !snippet.Burn {
+		sum := sha256.Sum256([]byte(snippet.Content))
+		h.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	}
+	// The ServeContent() function takes care of conditional and range
+	// requests, and HEAD requests.
+	http.ServeContent(w, r, "", time.Time{}, strings.NewReader(snippet.Content))
+}
+
+// The DownloadFilename() function returns the name of the file for a
+// downloaded snippet, made from the words in its title and an extension for
+// its language, such as "hello-world.go". Snippets whose language isn't set
+// have it detected from their content.
+func DownloadFilename(snippet *models.Snippet) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(strings.ToLower(snippet.Title), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	}) {
+		if b.Len()+len(word) > 60 {
+			break
+		}
+		if b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		b.WriteString(word)
+	}
+	name := b.String()
+	if name == "" {
+		name = snippet.Slug
+	}
+
+	language := snippet.Language
+	switch {
+	case snippet.Encrypted:
+		language = "plaintext"
+	case language == "":
+		language = syntax.Detect(snippet.Content)
+	}
+	return name + syntax.Extension(language)
+}
+
+// The Unlocked() helper reports whether the current user may see the content
+// of a snippet: either it has no password, they own it, or they've entered
+// the password during this session.
+func (app *App) Unlocked(r *http.Request, snippet *models.Snippet) bool {
+	if !snippet.Protected() || app.IsOwner(r, snippet) {
+		return true
+	}
+	return app.Sessions.GetString(r.Context(), unlockKey(snippet)) == passwordFingerprint(snippet)
+}
+
+// The Unlock() helper remembers in the current user's session that they have
+// entered the snippet's password.
+func (app *App) Unlock(r *http.Request, snippet *models.Snippet) {
+	app.Sessions.Put(r.Context(), unlockKey(snippet), passwordFingerprint(snippet))
+}
+
+// The unlockKey() function returns the session key recording that a snippet
+// has been unlocked.
+func unlockKey(snippet *models.Snippet) string {
+	return "unlocked:" + strconv.Itoa(snippet.ID)
+}
+
+// The passwordFingerprint() function identifies a snippet's current password
+// hash, so that unlocks are forgotten when the owner changes the password. A
+// SHA-256 digest is used so that the hash itself never leaves the server.
+func passwordFingerprint(snippet *models.Snippet) string {
+	sum := sha256.Sum256(snippet.HashedPassword)
+	return hex.EncodeToString(sum[:8])
+}
+
+// The ClientIP() helper returns the IP address of the client making the
+// request, for rate limiting.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// The OwnedSnippet() helper works like RequestedSnippet(), but also checks
+// that the snippet belongs to the current user, sending a 403 Forbidden
+// response if it belongs to somebody else.
+func (app *App) OwnedSnippet(w http.ResponseWriter, r *http.Request) *models.Snippet {
+	snippet := app.RequestedSnippet(w, r)
+	if snippet == nil {
+		return nil
+	}
+	if !app.IsOwner(r, snippet) {
+		app.ClientError(w, http.StatusForbidden)
+		return nil
+	}
+	return snippet
+}
+
+// The RequestedRevision() helper fetches a revision of a snippet, given the
+// revision number as a string. If the revision doesn't exist it sends a 404
+// Not Found response and returns nil.
+func (app *App) RequestedRevision(w http.ResponseWriter, snippetID int, number string) *models.Revision {
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 {
+		app.NotFound(w)
+		return nil
+	}
+	revision, err := app.Database.GetRevision(snippetID, n)
+	if err != nil {
+		app.ServerError(w, err)
+		return nil
+	}
+	if revision == nil {
+		app.NotFound(w)
+		return nil
+	}
+	return revision
+}
+
+// The PagedSnippets() helper fetches one page of snippets matching the filter,
+// starting from the cursor in the ?before= or ?after= query string parameter.
+// It returns the snippets along with the links to the adjacent pages. If the
+// cursor is invalid it sends a 400 Bad Request response and returns a nil
+// *PageData.
+func (app *App) PagedSnippets(w http.ResponseWriter, r *http.Request, filter models.SnippetFilter) (models.Snippets, *PageData) {
+	snippets, page, err := app.SnippetPage(r.URL.Query(), filter)
+	if err == ErrBadCursor {
+		app.ClientError(w, http.StatusBadRequest)
+		return nil, nil
+	} else if err != nil {
+		app.ServerError(w, err)
+		return nil, nil
+	}
+	return snippets, page
+}
+
+// ErrBadCursor is returned by SnippetPage() if the cursor in the query string
+// is invalid.
+var ErrBadCursor = errors.New("invalid page cursor")
+
+// The SnippetPage() helper does the work of PagedSnippets(), returning any
+// error instead of sending a response, so that it can also be used by the
+// API.
+func (app *App) SnippetPage(q url.Values, filter models.SnippetFilter) (models.Snippets, *PageData, error) {
+	var err error
+	if s := q.Get("before"); s != "" {
+		if filter.Before, err = models.ParseCursor(s); err != nil {
+			return nil, nil, ErrBadCursor
+		}
+	} else if s := q.Get("after"); s != "" {
+		if filter.After, err = models.ParseCursor(s); err != nil {
+			return nil, nil, ErrBadCursor
+		}
+	}
+	filter.Limit = 10
+
+	snippets, more, err := app.Database.PageSnippets(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// There are newer snippets if we paged backwards to get here, or if we're
+	// paging forwards and the database said there are more. Likewise for
+	// older snippets.
+	page := &PageData{}
+	if len(snippets) == 0 {
+		return snippets, page, nil
+	}
+	hasNewer := filter.Before != nil || (filter.After != nil && more)
+	hasOlder := filter.After != nil || more
+	q.Del("before")
+	q.Del("after")
+	if hasNewer {
+		q.Set("after", models.CursorOf(snippets[0]).String())
+		page.Newer = "?" + q.Encode()
+		q.Del("after")
+	}
+	if hasOlder {
+		q.Set("before", models.CursorOf(snippets[len(snippets)-1]).String())
+		page.Older = "?" + q.Encode()
+	}
+	return snippets, page, nil
+}
+
+// The ExpiresAt() helper returns the expiry time to store for a validated
+// snippet form, which is models.Never if the snippet doesn't expire.
+func ExpiresAt(form *forms.NewSnippet) time.Time {
+	if form.ExpiresTime.IsZero() {
+		return models.Never
+	}
+	return form.ExpiresTime
+}
@@ -3,17 +3,49 @@ package main
 import (
 	"database/sql"
 	"flag"
-	"github.com/alexedwards/scs"
+	"github.com/alexedwards/scs/v2"
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"io"
 	"log"
+	"os"
+	migrations "sinistra/snippetbox/db_migrations"
 	"sinistra/snippetbox/models"
+	"sinistra/snippetbox/pkg/forms"
+	"sinistra/snippetbox/pkg/keyring"
+	"sinistra/snippetbox/pkg/migrate"
+	"sinistra/snippetbox/pkg/ratelimit"
+	"strings"
 	"time"
 )
 
 func main() {
 	addr := flag.String("addr", ":4000", "HTTP network address")
-	dsn := flag.String("dsn", "root:root@/snippetbox?parseTime=true", "MySQL DSN")
+	// Define a driver flag which selects the storage backend. The -dsn flag should
+	// then be a MySQL DSN, a PostgreSQL connection string or, for SQLite, the
+	// path to the database file.
+	driver := flag.String("driver", "mysql", "Database driver (mysql, postgres or sqlite3)")
+	dsn := flag.String("dsn", "root:root@/snippetbox?parseTime=true", "Database DSN")
+	// Define flags for the expiry times offered on the new snippet form. Presets
+	// are a number followed by m, h, d, w, mo or y, or "never".
+	customExpiry := flag.Bool("custom-expiry", true, "Allow snippets to expire at a custom date and time")
+	defaultExpiry := flag.String("default-expiry", "1y", "Expiry preset selected by default")
+	expiryPresets := flag.String("expiry-presets", "10m,1h,1d,1w,1mo,1y,never", "Comma-separated expiry presets")
+	maxExpiry := flag.Duration("max-expiry", 0, "Maximum time until a snippet expires (0 for no limit)")
 	htmlDir := flag.String("html-dir", "./ui/html", "Path to HTML templates")
+	// Define flags for the master keys used to encrypt snippet content at rest.
+	// The keys are read from a file, or from the environment variable with the
+	// given name, with one "<id> <base64 key>" line per key and the current key
+	// first. Encryption is disabled if neither is given.
+	masterKeyFile := flag.String("master-key-file", "", "Path to the master keys for encrypting snippets at rest")
+	masterKeyEnv := flag.String("master-key-env", "", "Environment variable holding the master keys, instead of a file")
+	migrateMode := flag.String("migrate", "", "Apply the embedded database migrations (up, down or status) and exit")
+	reencrypt := flag.Bool("reencrypt", false, "Re-encrypt all snippets with the current master key and exit")
+	reapBatch := flag.Int("reap-batch", 500, "Maximum number of expired snippets to delete at a time")
+	reapGrace := flag.Duration("reap-grace", time.Hour, "How long to keep snippets after they expire")
+	reapInterval := flag.Duration("reap-interval", 10*time.Minute, "How often to delete expired snippets (0 to disable)")
+	burnedRetention := flag.Duration("burned-retention", 30*24*time.Hour, "How long to remember burned snippets for their 410 page (0 to keep forever)")
 	// Define a new command-line flag for the session secret (a random key which
 	// will be used to encrypt and authenticate session cookies). It should be 32
 	// characters long.
@@ -21,34 +53,121 @@ func main() {
 	staticDir := flag.String("static-dir", "./ui/static", "Path to static assets")
 	tlsCert := flag.String("tls-cert", "./tls/cert.pem", "Path to TLS certificate")
 	tlsKey := flag.String("tls-key", "./tls/key.pem", "Path to TLS key")
+	// Define flags limiting how many wrong passwords a client can try for a
+	// password-protected snippet.
+	unlockAttempts := flag.Int("unlock-attempts", 5, "Failed snippet password attempts allowed per client in each window")
+	unlockWindow := flag.Duration("unlock-window", 15*time.Minute, "Window for counting failed snippet password attempts")
+	uploadLimit := flag.Int("upload-limit", 60, "Raw uploads allowed per user with an API token in each window")
+	anonUploadLimit := flag.Int("anon-upload-limit", 10, "Raw uploads allowed per client without an API token in each window")
+	uploadWindow := flag.Duration("upload-window", time.Hour, "Window for counting raw uploads")
 
 	flag.Parse()
 
+	if *reapBatch < 1 {
+		log.Fatal("-reap-batch must be at least 1")
+	}
+	if *burnedRetention < 0 {
+		log.Fatal("-burned-retention can't be negative")
+	}
+	if *unlockAttempts < 1 {
+		log.Fatal("-unlock-attempts must be at least 1")
+	}
+	if *uploadLimit < 0 || *anonUploadLimit < 0 {
+		log.Fatal("-upload-limit and -anon-upload-limit can't be negative")
+	}
+	expiry, err := forms.NewExpiryPolicy(*expiryPresets, *defaultExpiry, *customExpiry, *maxExpiry)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// To keep the main() function tidy I've put the code for creating a connection
-	// pool into the separate connect() function below. We pass connect() the DSN
-	// from the command-line flag.
-	db := connect(*dsn)
+	// pool into the separate connect() function below. We pass connect() the driver
+	// name and DSN from the command-line flags.
+	db := connect(*driver, *dsn)
 	// We also defer a call to db.Close(), so that the connection pool is closed
 	// before the main() function exits.
 	defer db.Close()
 
-	// Use the scs.NewCookieManager() function to initialize a new session manager,
-	// passing in the secret key as the parameter. Then we configure it so the
-	// session always expires after 12 hours and sessions are persisted across
-	// browser restarts.
+	// Load the embedded migrations for the chosen driver. If the -migrate flag was
+	// given we apply them and exit. Otherwise we refuse to start the server while
+	// the database schema is behind the code.
+	fsys, err := migrations.ForDriver(*driver)
+	if err != nil {
+		log.Fatal(err)
+	}
+	migrator, err := migrate.New(db, *driver, fsys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *migrateMode != "" {
+		runMigrations(migrator, *migrateMode)
+		return
+	}
+	pending, err := migrator.Pending()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(pending) > 0 {
+		log.Fatalf("database schema is %d migration(s) behind, run with -migrate=up", len(pending))
+	}
+
+	keys := loadKeys(*masterKeyFile, *masterKeyEnv)
+
+	// Wrap the connection pool in the models implementation for the chosen driver.
+	var database models.Store
+	switch *driver {
+	case "mysql":
+		database = &models.Database{DB: db, Keys: keys}
+	case "postgres":
+		database = &models.PostgresDatabase{DB: db, Keys: keys}
+	case "sqlite3":
+		database = &models.SQLiteDatabase{DB: db, Keys: keys}
+	default:
+		log.Fatalf("unsupported database driver %q", *driver)
+	}
+
+	// If the -reencrypt flag was given, seal any snippets which aren't already
+	// sealed with the current master key and exit. Once this has finished, the
+	// old keys can be removed from the keyring.
+	if *reencrypt {
+		if keys == nil {
+			log.Fatal("-reencrypt needs -master-key-file or -master-key-env")
+		}
+		n, err := database.(models.Reencrypter).ReencryptContent(100)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("re-encrypted %d row(s) with master key %q", n, keys.Current())
+		return
+	}
+
+	// Use the scs.New() function to initialize a new session manager, which
+	// keeps the session data in memory and gives each visitor a random session
+	// token in a cookie. Then we configure it so the session always expires
+	// after 12 hours, sessions are persisted across browser restarts, and the
+	// cookie is only sent over HTTPS.
 	sessionManager := scs.New()
 	sessionManager.Lifetime = 12 * time.Hour
 	sessionManager.Cookie.Persist = true
+	sessionManager.Cookie.Secure = true
 
 	// Add the *staticDir value to our application dependencies.
 	app := &App{
-		Addr:      *addr,
-		Database:  &models.Database{db},
-		HTMLDir:   *htmlDir,
-		Sessions:  sessionManager,
-		StaticDir: *staticDir,
-		TLSCert:   *tlsCert,
-		TLSKey:    *tlsKey,
+		Addr:            *addr,
+		AnonUploadLimit: ratelimit.New(*anonUploadLimit, *uploadWindow),
+		BurnedRetention: *burnedRetention,
+		Database:        database,
+		Expiry:          expiry,
+		HTMLDir:         *htmlDir,
+		ReapBatchSize:   *reapBatch,
+		ReapGrace:       *reapGrace,
+		ReapInterval:    *reapInterval,
+		Sessions:        sessionManager,
+		StaticDir:       *staticDir,
+		TLSCert:         *tlsCert,
+		TLSKey:          *tlsKey,
+		UnlockLimit:     ratelimit.New(*unlockAttempts, *unlockWindow),
+		UploadLimit:     ratelimit.New(*uploadLimit, *uploadWindow),
 	}
 
 	// Pass the app.Routes() method (which returns a serve mux) to the
@@ -59,9 +178,59 @@ func main() {
 
 }
 
-// The connect() function wraps sql.Open() and returns a sql.DB connection pool for a given DSN.
-func connect(dsn string) *sql.DB {
-	db, err := sql.Open("mysql", dsn)
+// The runMigrations() function carries out the action given by the -migrate flag.
+func runMigrations(migrator *migrate.Migrator, mode string) {
+	var err error
+	switch mode {
+	case "up":
+		err = migrator.Up()
+	case "down":
+		err = migrator.Down()
+	case "status":
+		err = migrator.Status(os.Stdout)
+	default:
+		log.Fatalf("unknown -migrate mode %q, expected up, down or status", mode)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// The loadKeys() function reads the master keys from the file or environment
+// variable named by the -master-key-file and -master-key-env flags. It returns
+// nil if neither flag was given, in which case content isn't encrypted.
+func loadKeys(file, env string) *keyring.Keyring {
+	var r io.Reader
+	switch {
+	case file != "" && env != "":
+		log.Fatal("-master-key-file and -master-key-env can't be used together")
+	case file != "":
+		f, err := os.Open(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		r = f
+	case env != "":
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			log.Fatalf("environment variable %s isn't set", env)
+		}
+		r = strings.NewReader(value)
+	default:
+		return nil
+	}
+	keys, err := keyring.Parse(r)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return keys
+}
+
+// The connect() function wraps sql.Open() and returns a sql.DB connection pool for a given
+// driver and DSN.
+func connect(driver, dsn string) *sql.DB {
+	db, err := sql.Open(driver, dsn)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -76,6 +245,11 @@ func connect(dsn string) *sql.DB {
 	// Set the maximum number of idle connections in the pool.
 	// Setting this to less than or equal to 0 will mean that no idle connections are retained.
 	db.SetMaxIdleConns(5)
+	// SQLite only allows a single writer at a time, so using more than one
+	// connection just leads to "database is locked" errors under load.
+	if driver == "sqlite3" {
+		db.SetMaxOpenConns(1)
+	}
 
 	if err := db.Ping(); err != nil {
 		log.Fatal(err)
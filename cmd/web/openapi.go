@@ -0,0 +1,20 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPI is the OpenAPI 3 document describing the routes in apiRoutes(). It's
+// embedded in the binary so that the document served always matches the code,
+// and routes_test.go checks that no route is missing from it.
+//
+//go:embed openapi.json
+var openAPI []byte
+
+// The OpenAPI handler serves the OpenAPI document, for generating API clients.
+func (app *App) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(openAPI)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sinistra/snippetbox/models"
+	"sinistra/snippetbox/pkg/forms"
+	"sinistra/snippetbox/pkg/syntax"
+	"time"
+)
+
+// The pastebin.com API is a single form POST to /api/api_post.php, which
+// replies with the URL of the new paste as plain text, or with an error
+// message starting "Bad API request, ". The PastebinPost handler speaks the
+// same protocol, so that existing scripts and editor plugins can use
+// snippetbox by changing their base URL.
+//
+// The api_dev_key which pastebin.com requires is accepted but ignored. To own
+// the new snippet, send a personal access token with the write scope as the
+// api_user_key, or in an "Authorization: Bearer" header. Other requests are
+// anonymous, and have the same quotas as raw uploads.
+
+// The pastebinExpiry map converts pastebin.com's api_paste_expire_date codes
+// to expiry preset names.
+var pastebinExpiry = map[string]string{
+	"10M": "10m",
+	"1H":  "1h",
+	"1D":  "1d",
+	"1W":  "1w",
+	"2W":  "2w",
+	"1M":  "1mo",
+	"6M":  "6mo",
+	"1Y":  "1y",
+	"N":   "never",
+}
+
+// The pastebinVisibility map converts pastebin.com's api_paste_private values
+// to visibilities.
+var pastebinVisibility = map[string]string{
+	"0": models.Public,
+	"1": models.Unlisted,
+	"2": models.Private,
+}
+
+// The pastebinFormats map holds the api_paste_format names which differ from
+// our language names. Other formats are used as they are if they're one of the
+// syntax.Languages, and otherwise the language is detected from the content.
+var pastebinFormats = map[string]string{
+	"text":       "plaintext",
+	"html5":      "html",
+	"make":       "makefile",
+	"dockerfile": "docker",
+}
+
+// PastebinError is the error for a request which pastebin.com would reject.
+// Its message is the text after "Bad API request, ".
+type PastebinError string
+
+func (e PastebinError) Error() string {
+	return "Bad API request, " + string(e)
+}
+
+// The PastebinPost handler creates a snippet from an api_post.php request.
+func (app *App) PastebinPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxAPIBody)
+	err := r.ParseForm()
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		PastebinReply(w, PastebinError("maximum paste file size exceeded"))
+		return
+	} else if err != nil {
+		app.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	if r.PostForm.Get("api_option") != "paste" {
+		PastebinReply(w, PastebinError("invalid api_option"))
+		return
+	}
+
+	token := RequestToken(r)
+	if key := r.PostForm.Get("api_user_key"); key != "" && token == nil {
+		token, err = app.Database.GetTokenByHash(models.HashToken(key))
+		if err != nil {
+			app.ServerError(w, err)
+			return
+		}
+		if token == nil {
+			PastebinReply(w, PastebinError("invalid or expired api_user_key"))
+			return
+		}
+	}
+	userID := 0
+	if token != nil {
+		if !token.Allows(models.ScopeWrite) {
+			PastebinReply(w, PastebinError("invalid api_user_key"))
+			return
+		}
+		userID = token.UserID
+	}
+
+	// Count the request before validating it, as UploadSnippet does, so that
+	// invalid pastes use up the quota too.
+	if !app.TakeUpload(r, userID) {
+		PastebinReply(w, errors.New("Post limit, maximum pastes per 24h reached"))
+		return
+	}
+	form, err := app.pastebinForm(r, userID)
+	if err != nil {
+		PastebinReply(w, err)
+		return
+	}
+
+	snippet := &models.Snippet{
+		UserID:     userID,
+		Title:      form.Title,
+		Content:    form.Content,
+		Expires:    ExpiresAt(form),
+		Visibility: form.Visibility,
+		Language:   form.Language,
+	}
+	err = app.Database.InsertSnippet(snippet)
+	if err != nil {
+		app.ServerError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, requestOrigin(r)+snippet.Path())
+}
+
+// The pastebinForm() method maps the api_paste_* fields onto a NewSnippet
+// form and validates it, returning a PastebinError with the message that
+// pastebin.com would send if it isn't valid.
+func (app *App) pastebinForm(r *http.Request, userID int) (*forms.NewSnippet, error) {
+	form := &forms.NewSnippet{
+		Title:      r.PostForm.Get("api_paste_name"),
+		Content:    r.PostForm.Get("api_paste_code"),
+		Expires:    app.Expiry.Default,
+		Visibility: models.Public,
+		Policy:     app.Expiry,
+	}
+	if form.Title == "" {
+		form.Title = "Untitled"
+	}
+
+	if code := r.PostForm.Get("api_paste_expire_date"); code != "" {
+		name, ok := pastebinExpiry[code]
+		if !ok {
+			return nil, PastebinError("invalid api_expire_date")
+		}
+		form.Expires, form.ExpiresAt = pastebinPreset(app.Expiry, name)
+	}
+	if private := r.PostForm.Get("api_paste_private"); private != "" {
+		visibility, ok := pastebinVisibility[private]
+		if !ok {
+			return nil, PastebinError("invalid api_paste_private")
+		}
+		// Nobody could see an anonymous private snippet.
+		if visibility == models.Private && userID == 0 {
+			return nil, PastebinError("invalid api_paste_private")
+		}
+		form.Visibility = visibility
+	}
+	if format := r.PostForm.Get("api_paste_format"); format != "" {
+		if language, ok := pastebinFormats[format]; ok {
+			form.Language = language
+		} else if syntax.Supported(format) {
+			form.Language = format
+		}
+	}
+
+	if !form.Valid() {
+		switch {
+		case form.Failures["Content"] != "":
+			return nil, PastebinError("api_paste_code was empty")
+		case form.Failures["Expires"] != "":
+			return nil, PastebinError("invalid api_expire_date")
+		case form.Failures["Title"] != "":
+			return nil, PastebinError("invalid api_paste_name")
+		default:
+			return nil, PastebinError("invalid api_paste_format")
+		}
+	}
+	return form, nil
+}
+
+// The pastebinPreset() function returns the Expires and ExpiresAt form values
+// for an expiry preset name. If the policy doesn't offer the preset, one with
+// the same duration is used instead, or failing that a custom expiry time if
+// they're allowed. Otherwise the name is returned as it is, for the form to
+// reject.
+func pastebinPreset(policy *forms.ExpiryPolicy, name string) (string, string) {
+	if _, ok := policy.Preset(name); ok {
+		return name, ""
+	}
+	wanted, err := forms.ParseExpiryPreset(name)
+	if err != nil {
+		return name, ""
+	}
+	for _, preset := range policy.Presets {
+		if preset.Duration == wanted.Duration {
+			return preset.Name, ""
+		}
+	}
+	if policy.AllowCustom && wanted.Duration > 0 {
+		t := time.Now().UTC().Add(wanted.Duration)
+		return "custom", t.Format(forms.DateTimeLayout)
+	}
+	return name, ""
+}
+
+// The PastebinReply() helper sends an error in the pastebin.com format.
+// Clients recognise errors by their text, so the status is 200 OK as it is
+// for successful requests.
+func PastebinReply(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, err.Error())
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// The ReapExpiredSnippets() method runs in the background for as long as the
+// server is running. Every ReapInterval it deletes the snippets which expired
+// more than ReapGrace ago, in batches of ReapBatchSize so that a large backlog
+// doesn't hold locks on the snippets table for a long time. It also forgets
+// snippets burned more than BurnedRetention ago, so that the burned_snippets
+// table doesn't grow forever. It returns when the stop channel is closed. A
+// ReapInterval of zero disables the reaper.
+func (app *App) ReapExpiredSnippets(stop <-chan struct{}) {
+	if app.ReapInterval <= 0 {
+		return
+	}
+	log.Printf("Reaping expired snippets every %s, with a grace period of %s", app.ReapInterval, app.ReapGrace)
+
+	ticker := time.NewTicker(app.ReapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			app.reap(stop)
+		}
+	}
+}
+
+// The reap() method deletes batches of expired snippets, and then of burned
+// snippet tombstones past their retention, until there are none left or the
+// stop channel is closed.
+func (app *App) reap(stop <-chan struct{}) {
+	now := time.Now().UTC()
+	total, finished := app.reapBatches(stop, app.Database.DeleteExpiredSnippets, now.Add(-app.ReapGrace))
+	if !finished {
+		log.Printf("Reaper stopped after deleting %d expired snippets", total)
+		return
+	}
+	if total > 0 {
+		log.Printf("Reaper deleted %d expired snippets", total)
+	}
+
+	if app.BurnedRetention <= 0 {
+		return
+	}
+	total, finished = app.reapBatches(stop, app.Database.DeleteBurnedSnippets, now.Add(-app.BurnedRetention))
+	if !finished {
+		log.Printf("Reaper stopped after forgetting %d burned snippets", total)
+		return
+	}
+	if total > 0 {
+		log.Printf("Reaper forgot %d burned snippets", total)
+	}
+}
+
+// The reapBatches() method calls a store's delete method with batches of
+// ReapBatchSize until a batch isn't full, and returns the total deleted. It
+// reports false if the stop channel was closed before it finished.
+func (app *App) reapBatches(stop <-chan struct{}, deleteBefore func(before time.Time, limit int) (int, error), before time.Time) (int, bool) {
+	total := 0
+	for {
+		n, err := deleteBefore(before, app.ReapBatchSize)
+		if err != nil {
+			log.Printf("Reaper: %s", err)
+			return total, true
+		}
+		total += n
+		if n < app.ReapBatchSize {
+			return total, true
+		}
+		select {
+		case <-stop:
+			return total, false
+		default:
+		}
+	}
+}
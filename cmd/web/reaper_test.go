@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sinistra/snippetbox/models"
+	"testing"
+	"time"
+)
+
+func TestReap(t *testing.T) {
+	store := models.NewMemoryStore()
+	insert := func(s *models.Snippet) *models.Snippet {
+		t.Helper()
+		s.Title, s.Content, s.Visibility = "Title", "Content", models.Unlisted
+		if err := store.InsertSnippet(s); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	now := time.Now().UTC()
+	// Three snippets are past the grace period, which is more than a batch.
+	for i := 0; i < 3; i++ {
+		insert(&models.Snippet{Expires: now.Add(-2 * time.Hour)})
+	}
+	insert(&models.Snippet{Expires: now.Add(-30 * time.Minute)})
+	live := insert(&models.Snippet{Expires: now.Add(time.Hour)})
+	burned := insert(&models.Snippet{Expires: now.Add(time.Hour), Burn: true})
+	if ok, err := store.BurnSnippet(burned); !ok || err != nil {
+		t.Fatalf("BurnSnippet() = %t, %v; want true, nil", ok, err)
+	}
+
+	// With no retention period, burned snippets are remembered forever.
+	app := &App{Database: store, ReapBatchSize: 2, ReapGrace: time.Hour}
+	app.reap(make(chan struct{}))
+
+	// GetSnippetBySlug() hides expired snippets whether or not they've been
+	// deleted, so count the expired snippets that are left by deleting them.
+	// Only the one still in its grace period should be, which also means that
+	// the reaper carried on after the first full batch.
+	if n, err := store.DeleteExpiredSnippets(time.Now().UTC(), 100); n != 1 || err != nil {
+		t.Errorf("expired snippets left after reaping = %d, %v; want 1, nil", n, err)
+	}
+	if s, _ := store.GetSnippetBySlug(live.Slug, 0); s == nil {
+		t.Error("the live snippet was deleted")
+	}
+	if ok, _ := store.SnippetBurned(burned.Slug); !ok {
+		t.Fatal("the burned snippet was forgotten without a retention period")
+	}
+
+	// The tombstone is a moment old, so a tiny retention period forgets it.
+	time.Sleep(time.Millisecond)
+	app.BurnedRetention = time.Nanosecond
+	app.reap(make(chan struct{}))
+	if ok, _ := store.SnippetBurned(burned.Slug); ok {
+		t.Error("the burned snippet wasn't forgotten after the retention period")
+	}
+}
+
+func TestReapStops(t *testing.T) {
+	store := models.NewMemoryStore()
+	for i := 0; i < 3; i++ {
+		s := &models.Snippet{Title: "Title", Content: "Content", Expires: time.Now().UTC().Add(-2 * time.Hour), Visibility: models.Public}
+		if err := store.InsertSnippet(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Once the stop channel is closed, the reaper finishes its current batch
+	// and leaves the rest for next time.
+	stop := make(chan struct{})
+	close(stop)
+	app := &App{Database: store, ReapBatchSize: 1, ReapGrace: time.Hour}
+	app.reap(stop)
+	if n, _ := store.DeleteExpiredSnippets(time.Now().UTC(), 100); n != 2 {
+		t.Errorf("expired snippets left after stopping = %d; want 2", n)
+	}
+}
@@ -3,29 +3,92 @@ package main
 import (
 	"github.com/bmizerany/pat"
 	"net/http"
+	"sinistra/snippetbox/models"
+	"sinistra/snippetbox/pkg/forms"
 )
 
 func (app *App) Routes() http.Handler {
 
+	// Resolve the expiry policy once, so the handlers and templates can use
+	// app.Expiry without checking whether it was set.
+	if app.Expiry == nil {
+		app.Expiry = forms.DefaultExpiryPolicy
+	}
+
 	// Declare a serve mux and define the routes in exactly the same as before.
 	mux := pat.New()
 	mux.Get("/", http.HandlerFunc(app.Home))
+	mux.Get("/snippets", http.HandlerFunc(app.Archive))
+	mux.Get("/search", http.HandlerFunc(app.Search))
 	mux.Get("/snippet/new", app.RequireLogin(http.HandlerFunc(app.NewSnippet)))
 	mux.Post("/snippet/new", app.RequireLogin(http.HandlerFunc(app.CreateSnippet)))
-	mux.Get("/snippet/:id", NoSurf(app.ShowSnippet))
+	mux.Post("/snippet/preview", app.RequireLogin(http.HandlerFunc(app.PreviewSnippet)))
+	mux.Get("/snippet/:slug", NoSurf(app.ShowSnippet))
+	mux.Post("/snippet/:slug/unlock", NoSurf(app.UnlockSnippet))
+	mux.Get("/snippet/:slug/edit", app.RequireLogin(NoSurf(app.EditSnippet)))
+	mux.Post("/snippet/:slug/edit", app.RequireLogin(NoSurf(app.UpdateSnippet)))
+	mux.Post("/snippet/:slug/delete", app.RequireLogin(NoSurf(app.DeleteSnippet)))
+	mux.Get("/snippet/:slug/history", http.HandlerFunc(app.SnippetHistory))
+	mux.Get("/snippet/:slug/rev/:n", http.HandlerFunc(app.ShowRevision))
+	mux.Get("/snippet/:slug/diff", http.HandlerFunc(app.DiffSnippet))
 	mux.Get("/user/signup", NoSurf(app.SignupUser))
 	mux.Post("/user/signup", NoSurf(app.CreateUser))
 	mux.Get("/user/login", NoSurf(app.LoginUser))
 	mux.Post("/user/login", NoSurf(app.VerifyUser))
+	mux.Get("/user/snippets", app.RequireLogin(http.HandlerFunc(app.UserSnippets)))
+	mux.Get("/user/account", app.RequireLogin(NoSurf(app.Account)))
+	mux.Post("/user/account/tokens", app.RequireLogin(NoSurf(app.CreateToken)))
+	mux.Post("/user/account/tokens/:id/revoke", app.RequireLogin(NoSurf(app.RevokeToken)))
 	mux.Post("/user/logout", app.RequireLogin(http.HandlerFunc(app.LogoutUser)))
 
+	// The routes which don't serve HTML are listed in apiRoutes(), so that the
+	// test can check they're all described in the OpenAPI document.
+	for _, route := range app.apiRoutes() {
+		if route.Method == "GET" {
+			// Pat's Get() also answers HEAD requests.
+			mux.Get(route.Pattern, route.Handler)
+		} else {
+			mux.Add(route.Method, route.Pattern, route.Handler)
+		}
+	}
+
 	// Use the app.StaticDir field as the location of the static file directory.
 	fileServer := http.FileServer(http.Dir(app.StaticDir))
 	mux.Get("/static/", http.StripPrefix("/static", fileServer))
 
 	// Pass the router as the 'next' parameter to the LogRequest middleware.
 	// Because LogRequest() is just a function, and the function returns a
-	// http.Handler we don't need to do anything else.
-	return LogRequest(SecureHeaders(mux))
+	// http.Handler we don't need to do anything else. The session manager's
+	// LoadAndSave middleware loads the session data for each request from its
+	// cookie, and saves any changes (setting the cookie) before the response
+	// is written.
+	return LogRequest(SecureHeaders(app.Sessions.LoadAndSave(mux)))
+
+}
+
+// route is an entry in the apiRoutes() table.
+type route struct {
+	Method  string
+	Pattern string
+	Handler http.Handler
+}
 
+// The apiRoutes() method returns the routes which serve plain text or JSON
+// rather than HTML. Each of them must be described in openapi.json. Every
+// JSON API route accepts a personal access token in place of the session
+// cookie.
+func (app *App) apiRoutes() []route {
+	return []route{
+		{"GET", "/snippet/:slug/raw", http.HandlerFunc(app.RawSnippet)},
+		{"GET", "/snippet/:slug/download", http.HandlerFunc(app.DownloadSnippet)},
+		{"GET", "/raw/:slug", http.HandlerFunc(app.RawSnippet)},
+		{"GET", "/api/openapi.json", http.HandlerFunc(app.OpenAPI)},
+		{"GET", "/api/v1/snippets", app.APIToken(http.HandlerFunc(app.APIListSnippets))},
+		{"POST", "/api/v1/snippets", app.APIToken(app.APIRequireLogin(models.ScopeWrite, http.HandlerFunc(app.APICreateSnippet)))},
+		{"GET", "/api/v1/snippets/:id", app.APIToken(http.HandlerFunc(app.APIShowSnippet))},
+		{"PATCH", "/api/v1/snippets/:id", app.APIToken(app.APIRequireLogin(models.ScopeWrite, http.HandlerFunc(app.APIUpdateSnippet)))},
+		{"DELETE", "/api/v1/snippets/:id", app.APIToken(app.APIRequireLogin(models.ScopeWrite, http.HandlerFunc(app.APIDeleteSnippet)))},
+		{"POST", "/", app.APIToken(http.HandlerFunc(app.UploadSnippet))},
+		{"POST", "/api/api_post.php", app.APIToken(http.HandlerFunc(app.PastebinPost))},
+	}
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// rxParam matches the named parameters in pat patterns, such as ":id".
+var rxParam = regexp.MustCompile(`:(\w+)`)
+
+func TestOpenAPIDescribesRoutes(t *testing.T) {
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPI, &spec); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %s", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("openapi.json has version %q; want 3.x", spec.OpenAPI)
+	}
+
+	app := &App{}
+	described := map[string]bool{}
+	for _, route := range app.apiRoutes() {
+		path := rxParam.ReplaceAllString(route.Pattern, "{$1}")
+		method := strings.ToLower(route.Method)
+		if _, ok := spec.Paths[path][method]; !ok {
+			t.Errorf("%s %s is missing from openapi.json", route.Method, path)
+		}
+		described[path+" "+method] = true
+	}
+
+	// Every operation in the document should also be a route, so that it
+	// doesn't describe routes which have been removed.
+	for path, item := range spec.Paths {
+		for method := range item {
+			if method == "parameters" || method == "summary" || method == "description" {
+				continue
+			}
+			if !described[path+" "+method] {
+				t.Errorf("openapi.json describes %s %s, which isn't in apiRoutes()", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -24,9 +28,43 @@ func (app *App) RunServer() {
 		ReadTimeout:    5 * time.Second,
 		WriteTimeout:   10 * time.Second,
 	}
+	// Start the background reaper which deletes expired snippets. Closing the
+	// stop channel tells it to finish, and it closes the reaped channel once it
+	// has.
+	stop := make(chan struct{})
+	reaped := make(chan struct{})
+	go func() {
+		app.ReapExpiredSnippets(stop)
+		close(reaped)
+	}()
+
+	// When we receive an interrupt or terminate signal, gracefully shut the
+	// server down, giving in-flight requests up to 30 seconds to complete.
+	shutdown := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		log.Printf("Shutting down server")
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown: %s", err)
+		}
+		close(shutdown)
+	}()
+
 	// Call the http.Server's ListenAndServeTLS() method to start the server,
 	// passing in the paths to the TLS certificate and corresponding private key.
 	log.Printf("Starting server on %s", app.Addr)
 	err := srv.ListenAndServeTLS(app.TLSCert, app.TLSKey)
-	log.Fatal(err)
+
+	// However the server stopped, wait for the reaper to finish before exiting.
+	close(stop)
+	<-reaped
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-shutdown
+	log.Printf("Server stopped")
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sinistra/snippetbox/models"
+	"sinistra/snippetbox/pkg/forms"
+	"sinistra/snippetbox/pkg/ratelimit"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// The UploadSnippet handler creates a snippet from the raw body of a POST to
+// "/", so that output can be piped straight into snippetbox:
+//
+//	cat log.txt | curl --data-binary @- https://snippets.example.com/
+//
+// A multipart/form-data body is also accepted, in which case the content is
+// the file in the "file" field, as sent by curl -F file=@log.txt. The title,
+// expiry and language are optional, and are taken from the query string or
+// from the X-Title, X-Expires and X-Language headers. Uploaded snippets are
+// unlisted, so that they don't appear on the home page.
+//
+// Requests with a personal access token create snippets owned by the token's
+// user, and are allowed UploadLimit snippets in each window. Anonymous
+// requests are limited to the lower AnonUploadLimit for each client. The
+// session cookie is ignored, as other sites could make a browser send it.
+//
+// The response is the URL of the new snippet as plain text, and errors are
+// plain text too.
+func (app *App) UploadSnippet(w http.ResponseWriter, r *http.Request) {
+	userID := 0
+	if token := RequestToken(r); token != nil {
+		if !token.Allows(models.ScopeWrite) {
+			app.ClientError(w, http.StatusForbidden)
+			return
+		}
+		userID = token.UserID
+	}
+	limiter, key := app.uploadLimit(r, userID)
+	if !limiter.Take(key) {
+		w.Header().Set("Retry-After", fmt.Sprint(int(limiter.Window.Seconds())))
+		app.ClientError(w, http.StatusTooManyRequests)
+		return
+	}
+
+	content, filename, err := uploadedContent(w, r)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		app.ClientError(w, http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	form := &forms.NewSnippet{
+		Title:      uploadParam(r, "title", "X-Title"),
+		Content:    content,
+		Expires:    uploadParam(r, "expires", "X-Expires"),
+		Visibility: models.Unlisted,
+		Language:   uploadParam(r, "language", "X-Language"),
+		Policy:     app.Expiry,
+	}
+	if form.Title == "" {
+		form.Title = filename
+	}
+	if form.Title == "" {
+		form.Title = "Untitled"
+	}
+	if form.Expires == "" {
+		form.Expires = app.Expiry.Default
+	}
+	if !form.Valid() {
+		UploadFailed(w, form.Failures)
+		return
+	}
+
+	snippet := &models.Snippet{
+		UserID:     userID,
+		Title:      form.Title,
+		Content:    form.Content,
+		Expires:    ExpiresAt(form),
+		Visibility: form.Visibility,
+		Language:   form.Language,
+	}
+	err = app.Database.InsertSnippet(snippet)
+	if err != nil {
+		app.ServerError(w, err)
+		return
+	}
+
+	url := requestOrigin(r) + snippet.Path()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Location", url)
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, url)
+}
+
+// The TakeUpload() helper counts an upload against the quota for the user, or
+// for the client if userID is 0, and reports whether it was allowed. Handlers
+// call it before validating the request, so rejected uploads count too.
+func (app *App) TakeUpload(r *http.Request, userID int) bool {
+	limiter, key := app.uploadLimit(r, userID)
+	return limiter.Take(key)
+}
+
+// The uploadLimit() helper returns the limiter which counts uploads for the
+// user, or for the client if userID is 0, along with the key to count them
+// under.
+func (app *App) uploadLimit(r *http.Request, userID int) (*ratelimit.Limiter, string) {
+	if userID != 0 {
+		return app.UploadLimit, fmt.Sprintf("user %d", userID)
+	}
+	return app.AnonUploadLimit, "anon " + ClientIP(r)
+}
+
+// The uploadedContent() function reads the content of an upload, which is
+// either the whole body or the "file" field of a multipart form, along with
+// the file's name if it has one. The content must be UTF-8 text of no more
+// than maxAPIBody bytes.
+func uploadedContent(w http.ResponseWriter, r *http.Request) (string, string, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxAPIBody)
+
+	var body io.Reader = r.Body
+	var filename string
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		reader, err := r.MultipartReader()
+		if err != nil {
+			return "", "", err
+		}
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return "", "", errors.New("missing file field")
+			} else if err != nil {
+				return "", "", err
+			}
+			if part.FormName() == "file" {
+				body, filename = part, part.FileName()
+				break
+			}
+		}
+	}
+
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return "", "", err
+	}
+	if !utf8.Valid(b) {
+		return "", "", errors.New("content must be UTF-8 text")
+	}
+	return string(b), filename, nil
+}
+
+// The uploadParam() function returns the named query string parameter, or the
+// header if the parameter isn't given.
+func uploadParam(r *http.Request, name, header string) string {
+	if v := r.URL.Query().Get(name); v != "" {
+		return v
+	}
+	return r.Header.Get(header)
+}
+
+// The UploadFailed() helper sends a 422 Unprocessable Entity response listing
+// the validation failures, one per line.
+func UploadFailed(w http.ResponseWriter, failures map[string]string) {
+	var lines []string
+	for field, message := range failures {
+		lines = append(lines, jsonField(field)+": "+message)
+	}
+	sort.Strings(lines)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	fmt.Fprintln(w, strings.Join(lines, "\n"))
+}
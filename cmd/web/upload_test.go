@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestUploadSnippet(t *testing.T) {
+	ts := newTestServer(t)
+
+	upload := func(path, body string) *http.Response {
+		t.Helper()
+		res, err := ts.client.Post(ts.URL+path, "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	res := upload("/?title=Log", "line one\nline two\n")
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("upload returned %d; want %d", res.StatusCode, http.StatusCreated)
+	}
+	location := res.Header.Get("Location")
+	if !strings.HasPrefix(location, ts.URL+"/snippet/") {
+		t.Fatalf("upload has Location %q; want a snippet URL", location)
+	}
+	res, body := ts.get(strings.TrimPrefix(location, ts.URL) + "/raw")
+	if res.StatusCode != http.StatusOK || body != "line one\nline two\n" {
+		t.Errorf("raw content = %d %q; want the uploaded content", res.StatusCode, body)
+	}
+
+	// Only "/" accepts uploads.
+	if res := upload("/elsewhere", "content"); res.StatusCode == http.StatusCreated {
+		t.Errorf("upload to /elsewhere returned %d", res.StatusCode)
+	}
+
+	// The second upload uses up the anonymous quota of two, and the
+	// Retry-After header gives the window of the anonymous limiter.
+	if res := upload("/", "second"); res.StatusCode != http.StatusCreated {
+		t.Fatalf("second upload returned %d; want %d", res.StatusCode, http.StatusCreated)
+	}
+	res = upload("/", "third")
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("third upload returned %d; want %d", res.StatusCode, http.StatusTooManyRequests)
+	}
+	if got := res.Header.Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q; want the anonymous window of 60 seconds", got)
+	}
+}
+
+func TestPastebinPostLimit(t *testing.T) {
+	ts := newTestServer(t)
+
+	paste := func(code string) string {
+		t.Helper()
+		res, err := ts.client.PostForm(ts.URL+"/api/api_post.php", url.Values{
+			"api_option":     {"paste"},
+			"api_paste_code": {code},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	// Pastes are counted before they're validated, as uploads are, so the
+	// empty paste uses up one of the anonymous quota of two.
+	if got := paste(""); got != "Bad API request, api_paste_code was empty" {
+		t.Fatalf("empty paste returned %q; want the empty code error", got)
+	}
+	if got := paste("first"); !strings.HasPrefix(got, ts.URL+"/snippet/") {
+		t.Fatalf("paste returned %q; want a snippet URL", got)
+	}
+	if got := paste("second"); got != "Post limit, maximum pastes per 24h reached" {
+		t.Errorf("paste over the limit returned %q; want the post limit error", got)
+	}
+}
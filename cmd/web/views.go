@@ -6,21 +6,78 @@ import (
 	"html/template"
 	"net/http"
 	"path/filepath"
+	"regexp"
 	"sinistra/snippetbox/models"
+	"sinistra/snippetbox/pkg/diff"
+	"sinistra/snippetbox/pkg/syntax"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // Define a new HTMLData struct to act as a wrapper for the dynamic data we want
 // to pass to our templates. For now this just contains the snippet data that we
 // want to display, which has the underling type *models.Snippet.
 type HTMLData struct {
-	CSRFToken string
-	Flash     string
-	Form      interface{}
-	LoggedIn  bool
-	Path      string
-	Snippet   *models.Snippet
-	Snippets  []*models.Snippet
+	Code          *CodeData
+	CSRFToken     string
+	CurrentUserID int
+	Diff          *DiffData
+	Flash         string
+	Form          interface{}
+	LoggedIn      bool
+	Markdown      template.HTML
+	Page          *PageData
+	Path          string
+	Revision      *models.Revision
+	Revisions     []*models.Revision
+	Search        *SearchData
+	Snippet       *models.Snippet
+	Snippets      []*models.Snippet
+	Token         string
+	Tokens        []*models.Token
+}
+
+// PageData holds the query strings linking to the newer and older pages of a
+// paginated list of snippets. They're empty when there is no such page.
+type PageData struct {
+	Newer string
+	Older string
+}
+
+// SearchData holds the query for the search page, and the query strings
+// linking to the previous and next pages of results.
+type SearchData struct {
+	Query string
+	Prev  string
+	Next  string
+}
+
+// DiffData holds the two revisions being compared on the diff page, and the
+// hunks of the unified diff between their contents. TooLarge is set when the
+// contents differ but are too large or too different to diff.
+type DiffData struct {
+	From     *models.Revision
+	To       *models.Revision
+	Hunks    []diff.Hunk
+	TooLarge bool
+}
+
+// CodeData holds the highlighted lines of a snippet or revision, and the name
+// of the language they were highlighted as.
+type CodeData struct {
+	Language string
+	Lines    []syntax.Line
+}
+
+// The highlightCode() function highlights content as the given language, or
+// the detected language if it's empty.
+func highlightCode(content, language string) (*CodeData, error) {
+	lines, language, err := syntax.Highlight(content, language)
+	if err != nil {
+		return nil, err
+	}
+	return &CodeData{Language: language, Lines: lines}, nil
 }
 
 // Create a humanDate function which returns a nicely formatted string
@@ -29,9 +86,89 @@ func humanDate(t time.Time) string {
 	return t.Format("02 Jan 2006 at 15:04")
 }
 
+// The highlight() function escapes text for inclusion in HTML, and wraps each
+// case-insensitive match of the search query's terms in a <mark> element.
+// Because it returns template.HTML, html/template won't escape the result
+// again, so it's important that everything except the <mark> tags is escaped
+// here.
+func highlight(text, query string) template.HTML {
+	rx := termsRegexp(query)
+	if rx == nil {
+		return template.HTML(template.HTMLEscapeString(text))
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, loc := range rx.FindAllStringIndex(text, -1) {
+		b.WriteString(template.HTMLEscapeString(text[last:loc[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(template.HTMLEscapeString(text[loc[0]:loc[1]]))
+		b.WriteString("</mark>")
+		last = loc[1]
+	}
+	b.WriteString(template.HTMLEscapeString(text[last:]))
+	return template.HTML(b.String())
+}
+
+// The languages() function returns the languages offered on the snippet forms.
+func languages() []syntax.Language {
+	return syntax.Languages
+}
+
+// The excerpt() function returns roughly 200 characters of text surrounding
+// the first match of the search query's terms, so that the search results
+// page shows the relevant part of long snippets.
+func excerpt(text, query string) string {
+	const width = 200
+	runes := []rune(text)
+	if len(runes) <= width {
+		return text
+	}
+	start := 0
+	if rx := termsRegexp(query); rx != nil {
+		if loc := rx.FindStringIndex(text); loc != nil {
+			start = utf8.RuneCountInString(text[:loc[0]]) - width/4
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > len(runes)-width {
+		start = len(runes) - width
+	}
+	result := string(runes[start : start+width])
+	if start > 0 {
+		result = "…" + result
+	}
+	if start+width < len(runes) {
+		result += "…"
+	}
+	return result
+}
+
+// The termsRegexp() function returns a case-insensitive regular expression
+// matching any of the query's search terms, or nil if there are none.
+func termsRegexp(query string) *regexp.Regexp {
+	terms := models.SearchTerms(query)
+	if len(terms) == 0 {
+		return nil
+	}
+	for i, term := range terms {
+		terms[i] = regexp.QuoteMeta(term)
+	}
+	return regexp.MustCompile("(?i)" + strings.Join(terms, "|"))
+}
+
 // Update the signature of RenderHTML() so that it accepts a new data parameter
 // containing a pointer to a HTMLData struct.
 func (app *App) RenderHTML(w http.ResponseWriter, r *http.Request, page string, data *HTMLData) {
+	app.RenderHTMLStatus(w, r, http.StatusOK, page, data)
+}
+
+// The RenderHTMLStatus() helper works like RenderHTML(), but sends the page
+// with the given status code. The status is only written once the template
+// has rendered, so that a template error can still send a 500 response.
+func (app *App) RenderHTMLStatus(w http.ResponseWriter, r *http.Request, status int, page string, data *HTMLData) {
 	// If no data has been passed in, initialize a new empty HTMLData object.
 	if data == nil {
 		data = &HTMLData{}
@@ -45,6 +182,7 @@ func (app *App) RenderHTML(w http.ResponseWriter, r *http.Request, page string,
 	// Add the logged in status to the HTMLData.
 	var err error
 	data.LoggedIn = app.LoggedIn(r)
+	data.CurrentUserID = app.CurrentUserID(r)
 	if err != nil {
 		app.ServerError(w, err)
 		return
@@ -58,7 +196,11 @@ func (app *App) RenderHTML(w http.ResponseWriter, r *http.Request, page string,
 	// which acts as a lookup between the names of our custom template functions and
 	// the functions themselves.
 	fm := template.FuncMap{
-		"humanDate": humanDate}
+		"excerpt":   excerpt,
+		"highlight": highlight,
+		"humanDate": humanDate,
+		"languages": languages,
+		"langLabel": syntax.Label}
 
 	// Our template.FuncMap must be registered with the template set before we call
 	// the ParseFiles() method. This means we have to use template.New() to create
@@ -82,5 +224,6 @@ func (app *App) RenderHTML(w http.ResponseWriter, r *http.Request, page string,
 	// Write the contents of the buffer to the http.ResponseWriter.
 	// Again, this is another time where we pass our http.ResponseWriter to a function that
 	// takes an io.Writer.
+	w.WriteHeader(status)
 	buf.WriteTo(w)
 }
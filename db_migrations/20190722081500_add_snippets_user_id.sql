@@ -0,0 +1,6 @@
+-- +goose Up
+ALTER TABLE snippets ADD COLUMN user_id INTEGER NULL;
+CREATE INDEX idx_snippets_user_id ON snippets (user_id);
+-- +goose Down
+DROP INDEX idx_snippets_user_id ON snippets;
+ALTER TABLE snippets DROP COLUMN user_id;
@@ -0,0 +1,4 @@
+-- +goose Up
+CREATE FULLTEXT INDEX idx_snippets_fulltext ON snippets (title, content);
+-- +goose Down
+DROP INDEX idx_snippets_fulltext ON snippets;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- The reaper deletes tombstones of burned snippets by the time they were
+-- burned, so index that column.
+CREATE INDEX idx_burned_snippets_burned ON burned_snippets (burned);
+-- +goose Down
+DROP INDEX idx_burned_snippets_burned ON burned_snippets;
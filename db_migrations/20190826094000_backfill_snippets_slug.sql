@@ -0,0 +1,13 @@
+-- +goose Up
+-- Every snippet is now reached by its slug. New slugs are ten base62
+-- characters generated by the application; existing snippets are given an
+-- "s" followed by 15 random hex digits, which is just as hard to guess and
+-- can never be mistaken for a numeric ID.
+UPDATE snippets SET slug = CONCAT('s', LOWER(LEFT(HEX(RANDOM_BYTES(8)), 15))) WHERE slug IS NULL;
+ALTER TABLE snippets MODIFY slug VARCHAR(16) NOT NULL;
+ALTER TABLE burned_snippets ADD COLUMN slug VARCHAR(16) NULL;
+CREATE INDEX idx_burned_snippets_slug ON burned_snippets (slug);
+-- +goose Down
+DROP INDEX idx_burned_snippets_slug ON burned_snippets;
+ALTER TABLE burned_snippets DROP COLUMN slug;
+ALTER TABLE snippets MODIFY slug VARCHAR(16) NULL;
@@ -0,0 +1,4 @@
+-- +goose Up
+ALTER TABLE snippets ADD COLUMN encrypted BOOLEAN NOT NULL DEFAULT FALSE;
+-- +goose Down
+ALTER TABLE snippets DROP COLUMN encrypted;
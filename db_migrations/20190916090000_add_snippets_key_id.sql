@@ -0,0 +1,10 @@
+-- +goose Up
+-- Content encrypted at rest is stored as base64, which is a third longer than
+-- the plaintext, so the content columns are widened to make room for it.
+ALTER TABLE snippets ADD COLUMN key_id VARCHAR(32) NULL, ADD COLUMN wrapped_key VARCHAR(128) NULL,
+    MODIFY content MEDIUMTEXT NOT NULL;
+ALTER TABLE snippet_revisions ADD COLUMN key_id VARCHAR(32) NULL, ADD COLUMN wrapped_key VARCHAR(128) NULL,
+    MODIFY content MEDIUMTEXT NOT NULL;
+-- +goose Down
+ALTER TABLE snippet_revisions DROP COLUMN key_id, DROP COLUMN wrapped_key, MODIFY content TEXT NOT NULL;
+ALTER TABLE snippets DROP COLUMN key_id, DROP COLUMN wrapped_key, MODIFY content TEXT NOT NULL;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- Only the SHA-256 hash of each token is stored. The prefix is the start of
+-- the token, so that users can tell their tokens apart.
+CREATE TABLE api_tokens
+(
+    id         INTEGER      NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id    INTEGER      NOT NULL,
+    name       VARCHAR(100) NOT NULL,
+    scope      VARCHAR(10)  NOT NULL,
+    token_hash CHAR(64)     NOT NULL UNIQUE,
+    prefix     VARCHAR(16)  NOT NULL,
+    created    DATETIME     NOT NULL,
+    last_used  DATETIME     NULL,
+    INDEX idx_api_tokens_user (user_id)
+);
+-- +goose Down
+DROP TABLE api_tokens;
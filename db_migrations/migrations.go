@@ -0,0 +1,27 @@
+// Package migrations embeds the goose-formatted SQL migrations into the
+// binary, so that the web application can apply them itself. The MySQL
+// migrations live at the top of the directory, and the PostgreSQL and SQLite
+// versions live in the postgres and sqlite subdirectories.
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+)
+
+//go:embed *.sql postgres/*.sql sqlite/*.sql
+var FS embed.FS
+
+// ForDriver returns the migrations for the given database/sql driver name.
+func ForDriver(driver string) (fs.FS, error) {
+	switch driver {
+	case "mysql":
+		return FS, nil
+	case "postgres":
+		return fs.Sub(FS, "postgres")
+	case "sqlite3":
+		return fs.Sub(FS, "sqlite")
+	}
+	return nil, fmt.Errorf("migrations: no migrations for driver %q", driver)
+}
@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE snippets
+(
+    id      SERIAL       NOT NULL PRIMARY KEY,
+    title   VARCHAR(255) NOT NULL,
+    content TEXT         NOT NULL,
+    created TIMESTAMP    NOT NULL,
+    expires TIMESTAMP    NOT NULL
+);
+CREATE INDEX idx_snippets_created ON snippets (created);
+-- +goose Down
+DROP INDEX idx_snippets_created;
+DROP TABLE snippets;
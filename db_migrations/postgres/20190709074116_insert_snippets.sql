@@ -0,0 +1,18 @@
+-- +goose Up
+INSERT INTO snippets (title, content, created, expires)
+VALUES ('An old silent pond',
+        E'An old silent pond...\nA frog jumps into the pond,\nsplash! Silence again.\n\n– Matsuo Basho',
+        NOW() AT TIME ZONE 'UTC',
+        NOW() AT TIME ZONE 'UTC' + INTERVAL '1 year');
+INSERT INTO snippets (title, content, created, expires)
+VALUES ('Over the wintry forest',
+        E'Over the wintry\nforest, winds howl in rage\nwith no leaves to blow.\n\n– Natsume Soseki',
+        NOW() AT TIME ZONE 'UTC',
+        NOW() AT TIME ZONE 'UTC' + INTERVAL '1 year');
+INSERT INTO snippets (title, content, created, expires)
+VALUES ('First autumn morning',
+        E'First autumn morning\nthe mirror I stare into\nshows my father''s face.\n\n– Murakami Kijo',
+        NOW() AT TIME ZONE 'UTC',
+        NOW() AT TIME ZONE 'UTC' + INTERVAL '1 minute');
+-- +goose Down
+TRUNCATE snippets;
@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE users
+(
+    id       SERIAL       NOT NULL PRIMARY KEY,
+    name     VARCHAR(255) NOT NULL,
+    email    VARCHAR(255) NOT NULL UNIQUE,
+    password CHAR(60)     NOT NULL,
+    created  TIMESTAMP    NOT NULL
+);
+
+-- +goose Down
+DROP TABLE users;
@@ -0,0 +1,4 @@
+-- +goose Up
+CREATE INDEX idx_snippets_fulltext ON snippets USING GIN (to_tsvector('english', title || ' ' || content));
+-- +goose Down
+DROP INDEX idx_snippets_fulltext;
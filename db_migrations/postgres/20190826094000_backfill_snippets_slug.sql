@@ -0,0 +1,11 @@
+-- +goose Up
+-- gen_random_uuid() is built in from PostgreSQL 13, and uses a secure random
+-- source unlike random().
+UPDATE snippets SET slug = 's' || substr(replace(gen_random_uuid()::text, '-', ''), 1, 15) WHERE slug IS NULL;
+ALTER TABLE snippets ALTER COLUMN slug SET NOT NULL;
+ALTER TABLE burned_snippets ADD COLUMN slug VARCHAR(16) NULL;
+CREATE INDEX idx_burned_snippets_slug ON burned_snippets (slug);
+-- +goose Down
+DROP INDEX idx_burned_snippets_slug;
+ALTER TABLE burned_snippets DROP COLUMN slug;
+ALTER TABLE snippets ALTER COLUMN slug DROP NOT NULL;
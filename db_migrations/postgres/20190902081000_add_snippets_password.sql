@@ -0,0 +1,4 @@
+-- +goose Up
+ALTER TABLE snippets ADD COLUMN password CHAR(60) NULL;
+-- +goose Down
+ALTER TABLE snippets DROP COLUMN password;
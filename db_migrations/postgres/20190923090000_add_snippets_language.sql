@@ -0,0 +1,4 @@
+-- +goose Up
+ALTER TABLE snippets ADD COLUMN language VARCHAR(32) NOT NULL DEFAULT '';
+-- +goose Down
+ALTER TABLE snippets DROP COLUMN language;
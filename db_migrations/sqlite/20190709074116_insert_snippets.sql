@@ -0,0 +1,18 @@
+-- +goose Up
+INSERT INTO snippets (title, content, created, expires)
+VALUES ('An old silent pond',
+        replace('An old silent pond...\nA frog jumps into the pond,\nsplash! Silence again.\n\n– Matsuo Basho', '\n', char(10)),
+        strftime('%Y-%m-%d %H:%M:%S+00:00', 'now'),
+        strftime('%Y-%m-%d %H:%M:%S+00:00', 'now', '+1 year'));
+INSERT INTO snippets (title, content, created, expires)
+VALUES ('Over the wintry forest',
+        replace('Over the wintry\nforest, winds howl in rage\nwith no leaves to blow.\n\n– Natsume Soseki', '\n', char(10)),
+        strftime('%Y-%m-%d %H:%M:%S+00:00', 'now'),
+        strftime('%Y-%m-%d %H:%M:%S+00:00', 'now', '+1 year'));
+INSERT INTO snippets (title, content, created, expires)
+VALUES ('First autumn morning',
+        replace('First autumn morning\nthe mirror I stare into\nshows my father''s face.\n\n– Murakami Kijo', '\n', char(10)),
+        strftime('%Y-%m-%d %H:%M:%S+00:00', 'now'),
+        strftime('%Y-%m-%d %H:%M:%S+00:00', 'now', '+1 minute'));
+-- +goose Down
+DELETE FROM snippets;
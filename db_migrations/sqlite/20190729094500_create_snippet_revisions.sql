@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE snippet_revisions
+(
+    id         INTEGER      NOT NULL PRIMARY KEY AUTOINCREMENT,
+    snippet_id INTEGER      NOT NULL,
+    revision   INTEGER      NOT NULL,
+    title      VARCHAR(255) NOT NULL,
+    content    TEXT         NOT NULL,
+    created    DATETIME     NOT NULL,
+    UNIQUE (snippet_id, revision)
+);
+INSERT INTO snippet_revisions (snippet_id, revision, title, content, created)
+SELECT id, 1, title, content, created FROM snippets;
+-- +goose Down
+DROP TABLE snippet_revisions;
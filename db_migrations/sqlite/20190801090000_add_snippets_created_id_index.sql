@@ -0,0 +1,8 @@
+-- +goose Up
+-- The snippet listings page through snippets by (created, id), so index both
+-- columns, replacing the index on created alone.
+CREATE INDEX idx_snippets_created_id ON snippets (created, id);
+DROP INDEX idx_snippets_created;
+-- +goose Down
+CREATE INDEX idx_snippets_created ON snippets (created);
+DROP INDEX idx_snippets_created_id;
@@ -0,0 +1,10 @@
+-- +goose Up
+ALTER TABLE snippets ADD COLUMN burn BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE TABLE burned_snippets
+(
+    snippet_id INTEGER  NOT NULL PRIMARY KEY,
+    burned     DATETIME NOT NULL
+);
+-- +goose Down
+DROP TABLE burned_snippets;
+ALTER TABLE snippets DROP COLUMN burn;
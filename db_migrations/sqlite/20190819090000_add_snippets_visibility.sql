@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE snippets ADD COLUMN visibility VARCHAR(8) NOT NULL DEFAULT 'public';
+ALTER TABLE snippets ADD COLUMN slug VARCHAR(16) NULL;
+CREATE UNIQUE INDEX idx_snippets_slug ON snippets (slug);
+-- +goose Down
+DROP INDEX idx_snippets_slug;
+ALTER TABLE snippets DROP COLUMN slug;
+ALTER TABLE snippets DROP COLUMN visibility;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- SQLite can't add a NOT NULL constraint to an existing column, so the slug
+-- column stays nullable here.
+UPDATE snippets SET slug = 's' || substr(lower(hex(randomblob(8))), 1, 15) WHERE slug IS NULL;
+ALTER TABLE burned_snippets ADD COLUMN slug VARCHAR(16) NULL;
+CREATE INDEX idx_burned_snippets_slug ON burned_snippets (slug);
+-- +goose Down
+DROP INDEX idx_burned_snippets_slug;
+ALTER TABLE burned_snippets DROP COLUMN slug;
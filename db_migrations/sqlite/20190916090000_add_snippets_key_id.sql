@@ -0,0 +1,10 @@
+-- +goose Up
+ALTER TABLE snippets ADD COLUMN key_id VARCHAR(32) NULL;
+ALTER TABLE snippets ADD COLUMN wrapped_key VARCHAR(128) NULL;
+ALTER TABLE snippet_revisions ADD COLUMN key_id VARCHAR(32) NULL;
+ALTER TABLE snippet_revisions ADD COLUMN wrapped_key VARCHAR(128) NULL;
+-- +goose Down
+ALTER TABLE snippet_revisions DROP COLUMN wrapped_key;
+ALTER TABLE snippet_revisions DROP COLUMN key_id;
+ALTER TABLE snippets DROP COLUMN wrapped_key;
+ALTER TABLE snippets DROP COLUMN key_id;
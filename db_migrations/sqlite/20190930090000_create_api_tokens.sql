@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE api_tokens
+(
+    id         INTEGER      NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER      NOT NULL,
+    name       VARCHAR(100) NOT NULL,
+    scope      VARCHAR(10)  NOT NULL,
+    token_hash CHAR(64)     NOT NULL UNIQUE,
+    prefix     VARCHAR(16)  NOT NULL,
+    created    DATETIME     NOT NULL,
+    last_used  DATETIME     NULL
+);
+CREATE INDEX idx_api_tokens_user ON api_tokens (user_id);
+-- +goose Down
+DROP TABLE api_tokens;
@@ -5,6 +5,9 @@ import (
 	"errors"
 	"github.com/go-sql-driver/mysql"
 	"golang.org/x/crypto/bcrypt"
+	"sinistra/snippetbox/pkg/keyring"
+	"strings"
+	"time"
 )
 
 // Create a new ErrInvalidCredentials error that we can return.
@@ -13,121 +16,303 @@ var (
 	ErrInvalidCredentials = errors.New("models: invalid user credentials")
 )
 
-// Declare a Database type (for now it's just an empty struct).
+// Declare a Database type (for now it's just an empty struct). If Keys is set,
+// snippet content is encrypted at rest with it.
 type Database struct {
 	*sql.DB
+	Keys *keyring.Keyring
 }
 
-// Implement a GetSnippet() method on the Database type. For now, this just returns
-// some dummy data, but later we'll update it to query our MySQL database for a
-// snippet with a specific ID. In particular, it returns a dummy snippet if the id
-// passed to the method equals 123, or returns nil otherwise.
-func (db *Database) GetSnippet(id int) (*Snippet, error) {
+// Implement a GetSnippet() method on the Database type. It queries our MySQL
+// database for an unexpired snippet with a specific ID, or returns nil if there
+// isn't one or the viewer isn't allowed to see it.
+func (db *Database) GetSnippet(id, viewerID int) (*Snippet, error) {
 	// Write the SQL statement we want to execute. I've split it over two lines
 	// for readability (which is why it's surrounded with backticks instead
 	// of normal double quotes).
-	stmt := `SELECT id, title, content, created, expires FROM snippets
-WHERE expires > UTC_TIMESTAMP() AND id = ?`
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+WHERE s.expires > UTC_TIMESTAMP() AND s.id = ? AND ` + viewableByID("?")
 	// Use the QueryRow() method on the embedded connection pool to execute our
 	// SQL statement, passing in the untrusted id variable as the value for the
-	// placeholder parameter. This returns a pointer to a sql.Row object which
-	// holds the result returned by the database.
-	row := db.QueryRow(stmt, id)
-	// Initialize a pointer to a new zeroed Snippet struct.
-	s := &Snippet{}
-	// Use row.Scan() to copy the values from each field in sql.Row to the
-	// corresponding field in the Snippet struct. Notice that the arguments
-	// to row.Scan are *pointers* to the place you want to copy the data into,
-	// and the number of arguments must be exactly the same as the number of
-	// columns returned by your statement. If our query returned no rows, then
-	// row.Scan() will return a sql.ErrNoRows error. We check for that and return
-	// nil instead of a Snippet object.
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	} else if err != nil {
-		return nil, err
-	}
-	// If everything went OK then return the Snippet object.
-	return s, nil
+	// placeholder parameter. The scanSnippet() helper copies the values from
+	// the returned row into a new Snippet, and returns nil if our query
+	// returned no rows.
+	return scanSnippet(db.Keys, db.QueryRow(stmt, id, viewerID))
+}
+
+// The GetSnippetBySlug() method works like GetSnippet(), but finds the snippet
+// by its slug, which also gives access to unlisted snippets.
+func (db *Database) GetSnippetBySlug(slug string, viewerID int) (*Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+WHERE s.expires > UTC_TIMESTAMP() AND s.slug = ? AND ` + viewableBySlug("?")
+	return scanSnippet(db.Keys, db.QueryRow(stmt, slug, viewerID))
 }
 
 func (db *Database) LatestSnippets() (Snippets, error) {
 	// Write the SQL statement we want to execute.
-	stmt := `SELECT id, title, content, created, expires FROM snippets WHERE expires > UTC_TIMESTAMP() ORDER BY created DESC LIMIT 10`
-	// Use the QueryRow() method on the embedded connection pool to execute our
-	// SQL statement. This results a sql.Rows resultset containing the result of
-	// our query.
-	rows, err := db.Query(stmt)
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+WHERE s.expires > UTC_TIMESTAMP() AND ` + listedSnippet + ` ORDER BY s.created DESC LIMIT 10`
+	// The querySnippets() helper executes the statement, iterates through the
+	// resultset and makes sure that it's properly closed afterwards.
+	return querySnippets(db.Keys, db, stmt)
+}
+
+// The PageSnippets() method returns a page of unexpired snippets using keyset
+// pagination, so that older pages are just as cheap to fetch as newer ones.
+func (db *Database) PageSnippets(f SnippetFilter) (Snippets, bool, error) {
+	stmt, args := pageQuery(f, []string{"s.expires > UTC_TIMESTAMP()", listedSnippet}, nil, questionMark)
+	snippets, err := querySnippets(db.Keys, db, stmt, args...)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	snippets, more := pageResults(snippets, f)
+	return snippets, more, nil
+}
+
+// The SearchSnippets() method uses the FULLTEXT index on the title and content
+// columns to find matching snippets, ordered by MySQL's relevance score. The
+// query is run in boolean mode, so that every term is required in the same way
+// as the other backends.
+func (db *Database) SearchSnippets(query string, page int) (Snippets, bool, error) {
+	query = booleanQuery(query)
+	if query == "" {
+		return Snippets{}, false, nil
 	}
-	// IMPORTANTLY we defer rows.Close() to ensure the sql.Rows resultset is
-	// always properly closed before LatestSnippets() returns. Closing a
-	// resultset is really important. As long as a resultset is open it will
-	// keep the underlying database connection open. So if something goes wrong
-	// in this method and the resultset isn't closed, it can rapidly lead to all
-	// the connections in your pool being used up. Another gotcha is that the
-	// defer statement should come *after* you check for an error from
-	// db.Query(). Otherwise, if db.Query() returns an error, you'll get a panic
-	// trying to close a nil resultset.
-	defer rows.Close()
-	// Initialize an empty Snippets object (remember that this is just a slice of
-	// the type []*Snippet).
-	snippets := Snippets{}
-	// Use rows.Next to iterate through the rows in the resultset. This
-	// prepares the first (and then each subsequent) row to be acted on by the
-	// rows.Scan() method. If iteration over all of the rows completes then the
-	// resultset automatically closes itself and frees-up the underlying
-	// database connection.
-	for rows.Next() {
-		// Create a pointer to a new zeroed Snippet object.
-		s := &Snippet{}
-		// Use rows.Scan() to copy the values from each field in the row to the
-		// new Snippet object that we created. Again, the arguments to row.Scan()
-		// must be pointers to the place you want to copy the data into, and the
-		// number of arguments must be exactly the same as the number of
-		// columns returned by your statement.
-		err := rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
-		if err != nil {
-			return nil, err
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+WHERE s.expires > UTC_TIMESTAMP() AND ` + listedSnippet + `
+AND MATCH(s.title, s.content) AGAINST(? IN BOOLEAN MODE)
+ORDER BY MATCH(s.title, s.content) AGAINST(? IN BOOLEAN MODE) DESC, s.created DESC
+LIMIT ? OFFSET ?`
+	snippets, err := querySnippets(db.Keys, db, stmt, query, query, SearchPageSize+1, searchOffset(page))
+	if err != nil {
+		return nil, false, err
+	}
+	snippets, more := searchResults(snippets)
+	return snippets, more, nil
+}
+
+// The booleanQuery() function converts a search query to a MySQL boolean mode
+// query requiring each of the SearchTerms. The terms are quoted, with any
+// double quotes removed, so that characters such as - and * in them aren't
+// taken as operators.
+func booleanQuery(query string) string {
+	var required []string
+	for _, term := range SearchTerms(query) {
+		term = strings.ReplaceAll(term, `"`, "")
+		if term != "" {
+			required = append(required, `+"`+term+`"`)
 		}
-		// Append it to the slice of snippets.
-		snippets = append(snippets, s)
-	}
-	// When the rows.Next() loop has finished we call rows.Err() to retrieve any
-	// error that was encountered during the iteration. It's important to
-	// call this - don't assume that a successful iteration was completed
-	// over the whole resultset.
-	if err = rows.Err(); err != nil {
-		return nil, err
 	}
-	// If everything went OK then return the Snippets slice.
-	return snippets, nil
+	return strings.Join(required, " ")
+}
+
+// The UserSnippets() method returns all the unexpired snippets created by a
+// specific user, newest first.
+func (db *Database) UserSnippets(userID int) (Snippets, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+WHERE s.expires > UTC_TIMESTAMP() AND s.user_id = ? ORDER BY s.created DESC`
+	return querySnippets(db.Keys, db, stmt, userID)
 }
 
-func (db *Database) InsertSnippet(title, content, expires string) (int, error) {
+// The InsertSnippet() method stores a new snippet. The withSlug() helper
+// generates its random slug, and retries the insert if the slug is already
+// taken.
+func (db *Database) InsertSnippet(s *Snippet) error {
+	return withSlug(s, func() error { return db.insertSnippet(s) }, mysqlDuplicate)
+}
+
+func (db *Database) insertSnippet(s *Snippet) error {
+	// The snippet and its first revision are inserted in a single transaction,
+	// so that we never end up with a snippet that has no history.
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	content, err := sealContent(db.Keys, s.Content)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 	// Write the SQL statement we want to execute.
-	stmt := `INSERT INTO snippets (title, content, created, expires)
-VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
-	// Use the db.Exec() method to execute the statement snippet, passing in values
-	// for our (untrusted) title, content and expiry placeholder parameters in
+	stmt := `INSERT INTO snippets (user_id, title, content, key_id, wrapped_key, created, expires, burn, visibility,
+slug, password, encrypted, language) VALUES(?, ?, ?, ?, ?, UTC_TIMESTAMP(), ?, ?, ?, ?, ?, ?, ?)`
+	// Use the tx.Exec() method to execute the statement snippet, passing in values
+	// for our (untrusted) title, content and expiry time placeholder parameters in
 	// exactly the same way that we did with the QueryRow() method. This returns
 	// a sql.Result object, which contains some basic information about what
 	// happened when the statement was executed.
-	result, err := db.Exec(stmt, title, content, expires)
+	result, err := tx.Exec(stmt, nullInt(s.UserID), s.Title, content.content, content.keyID, content.wrappedKey,
+		s.Expires, s.Burn, s.Visibility, s.Slug, nullBytes(s.HashedPassword), s.Encrypted, s.Language)
 	if err != nil {
-		return 0, err
+		tx.Rollback()
+		return err
 	}
 	// Use the LastInsertId() method on the result object to get the ID of our
 	// newly inserted record in the snippets table.
 	id, err := result.LastInsertId()
 	if err != nil {
-		return 0, err
+		tx.Rollback()
+		return err
+	}
+	if err = db.insertRevision(tx, int(id), s.Title, s.Content); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
 	}
 	// The ID returned is of type int64, so we convert it to an int type for
-	// returning from our Insert function.
-	return int(id), nil
+	// storing in the snippet.
+	s.ID = int(id)
+	return nil
+}
+
+// The UpdateSnippet() method replaces the title, content, expiry time,
+// visibility, password and language of a snippet. If the title or content has
+// changed a new revision is recorded.
+func (db *Database) UpdateSnippet(s *Snippet) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Lock the row while we compare the current title and content with the
+	// new values, so that concurrent edits can't both record a revision with
+	// the same number.
+	var oldTitle, oldContent, oldKeyID, oldWrappedKey string
+	row := tx.QueryRow(`SELECT title, content, COALESCE(key_id, ''), COALESCE(wrapped_key, '') FROM snippets
+WHERE id = ? FOR UPDATE`, s.ID)
+	if err = row.Scan(&oldTitle, &oldContent, &oldKeyID, &oldWrappedKey); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if oldContent, err = openContent(db.Keys, oldContent, oldKeyID, oldWrappedKey); err != nil {
+		tx.Rollback()
+		return err
+	}
+	content, err := sealContent(db.Keys, s.Content)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	stmt := `UPDATE snippets SET title = ?, content = ?, key_id = ?, wrapped_key = ?, expires = ?, visibility = ?,
+password = ?, language = ? WHERE id = ?`
+	_, err = tx.Exec(stmt, s.Title, content.content, content.keyID, content.wrappedKey, s.Expires, s.Visibility,
+		nullBytes(s.HashedPassword), s.Language, s.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if s.Title != oldTitle || s.Content != oldContent {
+		if err = db.insertRevision(tx, s.ID, s.Title, s.Content); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *Database) DeleteSnippet(id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM snippet_revisions WHERE snippet_id = ?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM snippets WHERE id = ?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *Database) BurnSnippet(s *Snippet) (bool, error) {
+	return burnSnippet(db.DB, s, questionMark)
+}
+
+func (db *Database) SnippetBurned(slug string) (bool, error) {
+	return snippetBurned(db.DB, slug, questionMark)
+}
+
+func (db *Database) DeleteExpiredSnippets(before time.Time, limit int) (int, error) {
+	return deleteExpired(db.DB, before, limit, questionMark)
+}
+
+func (db *Database) DeleteBurnedSnippets(before time.Time, limit int) (int, error) {
+	return deleteBurned(db.DB, before, limit, questionMark)
+}
+
+// The insertRevision() method records the given title and content as the
+// next revision of a snippet. Each revision's content is sealed with its own
+// data key.
+func (db *Database) insertRevision(tx *sql.Tx, snippetID int, title, content string) error {
+	c, err := sealContent(db.Keys, content)
+	if err != nil {
+		return err
+	}
+	stmt := `INSERT INTO snippet_revisions (snippet_id, revision, title, content, key_id, wrapped_key, created)
+SELECT ?, COALESCE(MAX(revision), 0) + 1, ?, ?, ?, ?, UTC_TIMESTAMP() FROM snippet_revisions WHERE snippet_id = ?`
+	_, err = tx.Exec(stmt, snippetID, title, c.content, c.keyID, c.wrappedKey, snippetID)
+	return err
+}
+
+// The SnippetRevisions() method returns all the revisions of a snippet, newest
+// first.
+func (db *Database) SnippetRevisions(snippetID int) ([]*Revision, error) {
+	stmt := `SELECT ` + revisionColumns + ` FROM snippet_revisions
+WHERE snippet_id = ? ORDER BY revision DESC`
+	return queryRevisions(db.Keys, db, stmt, snippetID)
+}
+
+// The GetRevision() method returns a specific revision of a snippet, or nil if
+// it doesn't exist.
+func (db *Database) GetRevision(snippetID, number int) (*Revision, error) {
+	stmt := `SELECT ` + revisionColumns + ` FROM snippet_revisions
+WHERE snippet_id = ? AND revision = ?`
+	revisions, err := queryRevisions(db.Keys, db, stmt, snippetID, number)
+	if err != nil || len(revisions) == 0 {
+		return nil, err
+	}
+	return revisions[0], nil
+}
+
+// The ReencryptContent() method seals the content of every snippet and
+// revision which isn't already sealed with the current master key, in batches,
+// and returns the number of rows changed. It's used after adding a new master
+// key, or when turning on encryption for an existing database.
+func (db *Database) ReencryptContent(batch int) (int, error) {
+	return reencryptAll(db.DB, db.Keys, batch, questionMark)
+}
+
+// The InsertToken() method stores a new personal API token, setting its ID and
+// creation time. The other token methods are shared with the other SQL
+// backends, as the statements are the same apart from their placeholders.
+func (db *Database) InsertToken(t *Token) error {
+	t.Created = time.Now().UTC()
+	stmt := `INSERT INTO api_tokens (user_id, name, scope, token_hash, prefix, created) VALUES(?, ?, ?, ?, ?, ?)`
+	result, err := db.Exec(stmt, t.UserID, t.Name, t.Scope, t.Hash, t.Prefix, t.Created)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	t.ID = int(id)
+	return nil
+}
+
+func (db *Database) UserTokens(userID int) ([]*Token, error) {
+	return userTokens(db.DB, userID, questionMark)
+}
+
+func (db *Database) DeleteToken(id, userID int) error {
+	return deleteToken(db.DB, id, userID, questionMark)
+}
+
+func (db *Database) GetTokenByHash(hash string) (*Token, error) {
+	return tokenByHash(db.DB, hash, questionMark)
 }
 
 func (db *Database) InsertUser(name, email, password string) error {
@@ -138,14 +323,13 @@ func (db *Database) InsertUser(name, email, password string) error {
 	}
 	stmt := `INSERT INTO users (name, email, password, created) VALUES(?, ?, ?, UTC_TIMESTAMP())`
 	// Insert the user details and hashed password into the users table. If there
-	// we type assert it to a *mysql.MySQLError object so we can check its
-	// specific error number. If it's error 1062 we return the ErrDuplicateEmail
-	// error instead of the one from MySQL.
+	// is an error, the mysqlDuplicate() helper checks whether it's MySQL's error
+	// 1062, in which case we return the ErrDuplicateEmail error instead of the
+	// one from MySQL. Other errors, such as a lost connection, aren't
+	// *mysql.MySQLError values, so they're returned as they are.
 	_, err = db.Exec(stmt, name, email, string(hashedPassword))
-	if err != nil {
-		if err.(*mysql.MySQLError).Number == 1062 {
-			return ErrDuplicateEmail
-		}
+	if mysqlDuplicate(err) {
+		return ErrDuplicateEmail
 	}
 	return err
 }
@@ -174,3 +358,10 @@ func (db *Database) VerifyUser(email, password string) (int, error) {
 	// Otherwise, the password is correct. Return the user ID.
 	return id, nil
 }
+
+// The mysqlDuplicate() function reports whether an error is MySQL's error 1062,
+// which means that a UNIQUE constraint was violated.
+func mysqlDuplicate(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == 1062
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"testing"
+)
+
+func TestMySQLDuplicate(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"no error", nil, false},
+		{"duplicate entry", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}, true},
+		{"other MySQL error", &mysql.MySQLError{Number: 1045, Message: "Access denied"}, false},
+		{"lost connection", driver.ErrBadConn, false},
+		{"other error", errors.New("something went wrong"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mysqlDuplicate(tt.err); got != tt.want {
+				t.Errorf("mysqlDuplicate(%v) = %t; want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBooleanQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"pond", `+"pond"`},
+		{"old silent  pond", `+"old" +"silent" +"pond"`},
+		{"  ", ""},
+		{"-frog +pond", `+"-frog" +"+pond"`},
+		{`"silent pond"`, `+"silent" +"pond"`},
+		{`"`, ""},
+		{"a b c d e f g h i j k l", `+"a" +"b" +"c" +"d" +"e" +"f" +"g" +"h" +"i" +"j"`},
+	}
+
+	for _, tt := range tests {
+		if got := booleanQuery(tt.query); got != tt.want {
+			t.Errorf("booleanQuery(%q) = %q; want %q", tt.query, got, tt.want)
+		}
+	}
+}
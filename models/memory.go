@@ -0,0 +1,498 @@
+package models
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory implementation of the Store interface. It's safe
+// for concurrent use and is intended for unit and integration tests, where
+// spinning up a MySQL database would be overkill.
+type MemoryStore struct {
+	mu        sync.Mutex
+	snippets  map[int]*Snippet
+	revisions map[int][]*Revision
+	burned    map[string]time.Time
+	users     map[string]*memoryUser
+	tokens    map[int]*Token
+	nextID    int
+	nextUser  int
+	nextToken int
+}
+
+type memoryUser struct {
+	id             int
+	name           string
+	hashedPassword []byte
+}
+
+// Check at compile time that the MemoryStore type satisfies the Store interface.
+var _ Store = &MemoryStore{}
+
+// NewMemoryStore returns an empty, ready to use, MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		snippets:  make(map[int]*Snippet),
+		revisions: make(map[int][]*Revision),
+		burned:    make(map[string]time.Time),
+		users:     make(map[string]*memoryUser),
+		tokens:    make(map[int]*Token),
+	}
+}
+
+func (m *MemoryStore) GetSnippet(id, viewerID int) (*Snippet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.snippets[id]
+	if !ok || !s.Expires.After(time.Now().UTC()) || !viewable(s, viewerID, Public) {
+		return nil, nil
+	}
+	return m.copySnippet(s), nil
+}
+
+func (m *MemoryStore) GetSnippetBySlug(slug string, viewerID int) (*Snippet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	for _, s := range m.snippets {
+		if s.Slug == slug && s.Expires.After(now) && viewable(s, viewerID, Public, Unlisted) {
+			return m.copySnippet(s), nil
+		}
+	}
+	return nil, nil
+}
+
+// The viewable() function is the equivalent of the viewableByID() and
+// viewableBySlug() SQL conditions. It reports whether the viewer owns the
+// snippet, or the snippet has one of the given visibilities.
+func viewable(s *Snippet, viewerID int, visibilities ...string) bool {
+	if s.UserID != 0 && s.UserID == viewerID {
+		return true
+	}
+	for _, v := range visibilities {
+		if s.Visibility == v {
+			return true
+		}
+	}
+	return false
+}
+
+// The copySnippet() method returns a copy of a stored snippet, so that callers
+// can't modify it, with the Author filled in from the users map. The caller
+// must hold the lock.
+func (m *MemoryStore) copySnippet(s *Snippet) *Snippet {
+	c := *s
+	for _, u := range m.users {
+		if u.id == s.UserID {
+			c.Author = u.name
+		}
+	}
+	return &c
+}
+
+// The listed() function is the equivalent of the listedSnippet SQL condition.
+func listed(s *Snippet) bool {
+	return !s.Burn && !s.Protected() && !s.Encrypted && s.Visibility == Public
+}
+
+// The filter() method returns copies of the unexpired snippets for which keep
+// returns true, newest first. The caller must hold the lock.
+func (m *MemoryStore) filter(keep func(s *Snippet) bool) Snippets {
+	now := time.Now().UTC()
+	snippets := Snippets{}
+	for _, s := range m.snippets {
+		if s.Expires.After(now) && keep(s) {
+			snippets = append(snippets, m.copySnippet(s))
+		}
+	}
+	// Mirror the ORDER BY created DESC clause used by the SQL queries. The ID
+	// is used as a tie-breaker so that the order is deterministic.
+	sort.Slice(snippets, func(i, j int) bool {
+		if snippets[i].Created.Equal(snippets[j].Created) {
+			return snippets[i].ID > snippets[j].ID
+		}
+		return snippets[i].Created.After(snippets[j].Created)
+	})
+	return snippets
+}
+
+func (m *MemoryStore) LatestSnippets() (Snippets, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snippets := m.filter(listed)
+	if len(snippets) > 10 {
+		snippets = snippets[:10]
+	}
+	return snippets, nil
+}
+
+func (m *MemoryStore) PageSnippets(f SnippetFilter) (Snippets, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snippets := m.filter(func(s *Snippet) bool {
+		switch {
+		case !listed(s):
+			return false
+		case !f.From.IsZero() && s.Created.Before(f.From):
+			return false
+		case !f.To.IsZero() && !s.Created.Before(f.To):
+			return false
+		case f.After != nil:
+			return s.Created.After(f.After.Created) || (s.Created.Equal(f.After.Created) && s.ID > f.After.ID)
+		case f.Before != nil:
+			return s.Created.Before(f.Before.Created) || (s.Created.Equal(f.Before.Created) && s.ID < f.Before.ID)
+		}
+		return true
+	})
+	// The filtered snippets are newest first. When paging forwards the page is
+	// the oldest Limit+1 of them, in ascending order, to match pageQuery().
+	if f.After != nil {
+		for i, j := 0, len(snippets)-1; i < j; i, j = i+1, j-1 {
+			snippets[i], snippets[j] = snippets[j], snippets[i]
+		}
+	}
+	if len(snippets) > f.Limit+1 {
+		snippets = snippets[:f.Limit+1]
+	}
+	snippets, more := pageResults(snippets, f)
+	return snippets, more, nil
+}
+
+// The SearchSnippets() method matches snippets containing every search term in
+// their title or content, case-insensitively, and ranks them in the same way
+// as the SQLite backend.
+func (m *MemoryStore) SearchSnippets(query string, page int) (Snippets, bool, error) {
+	terms := SearchTerms(query)
+	if len(terms) == 0 {
+		return Snippets{}, false, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	scores := make(map[int]int)
+	snippets := m.filter(func(s *Snippet) bool {
+		if !listed(s) {
+			return false
+		}
+		title, content := strings.ToLower(s.Title), strings.ToLower(s.Content)
+		for _, term := range terms {
+			term = strings.ToLower(term)
+			inTitle, inContent := strings.Contains(title, term), strings.Contains(content, term)
+			if !inTitle && !inContent {
+				return false
+			}
+			if inTitle {
+				scores[s.ID] += 2
+			}
+			if inContent {
+				scores[s.ID]++
+			}
+		}
+		return true
+	})
+	// The filtered snippets are already newest first, so a stable sort by
+	// score keeps that as the tie-breaker.
+	sort.SliceStable(snippets, func(i, j int) bool {
+		return scores[snippets[i].ID] > scores[snippets[j].ID]
+	})
+
+	offset := searchOffset(page)
+	if offset >= len(snippets) {
+		return Snippets{}, false, nil
+	}
+	snippets = snippets[offset:]
+	if len(snippets) > SearchPageSize+1 {
+		snippets = snippets[:SearchPageSize+1]
+	}
+	snippets, more := searchResults(snippets)
+	return snippets, more, nil
+}
+
+func (m *MemoryStore) UserSnippets(userID int) (Snippets, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.filter(func(s *Snippet) bool { return s.UserID == userID }), nil
+}
+
+// errSlugTaken plays the part of the UNIQUE constraint on the slug column.
+var errSlugTaken = errors.New("models: slug already taken")
+
+// The checkSlug() method returns errSlugTaken if a snippet already has the
+// same slug as s. The caller must hold the lock.
+func (m *MemoryStore) checkSlug(s *Snippet) error {
+	for _, other := range m.snippets {
+		if other.Slug == s.Slug {
+			return errSlugTaken
+		}
+	}
+	return nil
+}
+
+func isSlugTaken(err error) bool {
+	return err == errSlugTaken
+}
+
+func (m *MemoryStore) InsertSnippet(s *Snippet) error {
+	now := time.Now().UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return withSlug(s, func() error {
+		if err := m.checkSlug(s); err != nil {
+			return err
+		}
+		m.nextID++
+		s.ID = m.nextID
+		m.snippets[s.ID] = &Snippet{
+			ID:             s.ID,
+			UserID:         s.UserID,
+			Title:          s.Title,
+			Content:        s.Content,
+			Created:        now,
+			Expires:        s.Expires,
+			Burn:           s.Burn,
+			Visibility:     s.Visibility,
+			Slug:           s.Slug,
+			HashedPassword: s.HashedPassword,
+			Encrypted:      s.Encrypted,
+			Language:       s.Language,
+		}
+		m.addRevision(s.ID, s.Title, s.Content, now)
+		return nil
+	}, isSlugTaken)
+}
+
+// The addRevision() method records the next revision of a snippet. The caller
+// must hold the lock.
+func (m *MemoryStore) addRevision(snippetID int, title, content string, created time.Time) {
+	m.revisions[snippetID] = append(m.revisions[snippetID], &Revision{
+		SnippetID: snippetID,
+		Number:    len(m.revisions[snippetID]) + 1,
+		Title:     title,
+		Content:   content,
+		Created:   created,
+	})
+}
+
+func (m *MemoryStore) UpdateSnippet(s *Snippet) error {
+	now := time.Now().UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.snippets[s.ID]
+	if !ok {
+		return nil
+	}
+	if s.Title != stored.Title || s.Content != stored.Content {
+		m.addRevision(s.ID, s.Title, s.Content, now)
+	}
+	stored.Title = s.Title
+	stored.Content = s.Content
+	stored.Expires = s.Expires
+	stored.Visibility = s.Visibility
+	stored.HashedPassword = s.HashedPassword
+	stored.Language = s.Language
+	return nil
+}
+
+func (m *MemoryStore) DeleteSnippet(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.snippets, id)
+	delete(m.revisions, id)
+	return nil
+}
+
+func (m *MemoryStore) BurnSnippet(s *Snippet) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.snippets[s.ID]
+	if !ok || !stored.Burn {
+		return false, nil
+	}
+	delete(m.snippets, s.ID)
+	delete(m.revisions, s.ID)
+	m.burned[stored.Slug] = time.Now().UTC()
+	return true, nil
+}
+
+func (m *MemoryStore) SnippetBurned(slug string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.burned[slug]
+	return ok, nil
+}
+
+func (m *MemoryStore) DeleteExpiredSnippets(before time.Time, limit int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for id, s := range m.snippets {
+		if n == limit {
+			break
+		}
+		if s.Expires.Before(before) {
+			delete(m.snippets, id)
+			delete(m.revisions, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) DeleteBurnedSnippets(before time.Time, limit int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for slug, burned := range m.burned {
+		if n == limit {
+			break
+		}
+		if burned.Before(before) {
+			delete(m.burned, slug)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) SnippetRevisions(snippetID int) ([]*Revision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := m.revisions[snippetID]
+	revisions := make([]*Revision, 0, len(stored))
+	for i := len(stored) - 1; i >= 0; i-- {
+		c := *stored[i]
+		revisions = append(revisions, &c)
+	}
+	return revisions, nil
+}
+
+func (m *MemoryStore) GetRevision(snippetID, number int) (*Revision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := m.revisions[snippetID]
+	if number < 1 || number > len(stored) {
+		return nil, nil
+	}
+	c := *stored[number-1]
+	return &c, nil
+}
+
+func (m *MemoryStore) InsertUser(name, email, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The users table has a UNIQUE constraint on the email column, so we
+	// enforce the same rule here.
+	if _, exists := m.users[email]; exists {
+		return ErrDuplicateEmail
+	}
+	m.nextUser++
+	m.users[email] = &memoryUser{
+		id:             m.nextUser,
+		name:           name,
+		hashedPassword: hashedPassword,
+	}
+	return nil
+}
+
+func (m *MemoryStore) VerifyUser(email, password string) (int, error) {
+	m.mu.Lock()
+	u, ok := m.users[email]
+	m.mu.Unlock()
+	if !ok {
+		return 0, ErrInvalidCredentials
+	}
+
+	err := bcrypt.CompareHashAndPassword(u.hashedPassword, []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, ErrInvalidCredentials
+	} else if err != nil {
+		return 0, err
+	}
+	return u.id, nil
+}
+
+func (m *MemoryStore) InsertToken(t *Token) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The api_tokens table has a UNIQUE constraint on the token_hash column.
+	for _, other := range m.tokens {
+		if other.Hash == t.Hash {
+			return errors.New("models: duplicate token hash")
+		}
+	}
+	m.nextToken++
+	t.ID = m.nextToken
+	t.Created = time.Now().UTC()
+	stored := *t
+	m.tokens[t.ID] = &stored
+	return nil
+}
+
+func (m *MemoryStore) UserTokens(userID int) ([]*Token, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tokens := []*Token{}
+	for _, t := range m.tokens {
+		if t.UserID == userID {
+			token := *t
+			tokens = append(tokens, &token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].ID > tokens[j].ID
+	})
+	return tokens, nil
+}
+
+func (m *MemoryStore) DeleteToken(id, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.tokens[id]; ok && t.UserID == userID {
+		delete(m.tokens, id)
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetTokenByHash(hash string) (*Token, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.tokens {
+		if t.Hash == hash {
+			t.LastUsed = time.Now().UTC()
+			token := *t
+			return &token, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,403 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The insert() helper stores a snippet expiring in an hour, with the given
+// owner and visibility, and returns it with its ID and slug filled in.
+func insert(t *testing.T, m *MemoryStore, userID int, visibility string) *Snippet {
+	t.Helper()
+	s := &Snippet{
+		UserID:     userID,
+		Title:      "An old silent pond",
+		Content:    "An old silent pond...",
+		Expires:    time.Now().UTC().Add(time.Hour),
+		Visibility: visibility,
+	}
+	if err := m.InsertSnippet(s); err != nil {
+		t.Fatalf("InsertSnippet() returned %s", err)
+	}
+	return s
+}
+
+func TestMemoryStoreInsertSnippet(t *testing.T) {
+	m := NewMemoryStore()
+	if err := m.InsertUser("Alice", "alice@example.com", "password123"); err != nil {
+		t.Fatalf("InsertUser() returned %s", err)
+	}
+	s := insert(t, m, 1, Public)
+	if s.ID != 1 || s.Slug == "" {
+		t.Fatalf("InsertSnippet() set ID %d and slug %q; want 1 and a slug", s.ID, s.Slug)
+	}
+
+	got, err := m.GetSnippetBySlug(s.Slug, 0)
+	if err != nil || got == nil {
+		t.Fatalf("GetSnippetBySlug() = %v, %v; want the snippet", got, err)
+	}
+	if got.Title != s.Title || got.Content != s.Content || got.Author != "Alice" {
+		t.Errorf("got snippet %q, %q by %q; want %q, %q by Alice", got.Title, got.Content, got.Author, s.Title, s.Content)
+	}
+	if got.Created.IsZero() {
+		t.Error("Created wasn't set")
+	}
+
+	revisions, err := m.SnippetRevisions(s.ID)
+	if err != nil || len(revisions) != 1 || revisions[0].Number != 1 {
+		t.Errorf("SnippetRevisions() = %v, %v; want the first revision", revisions, err)
+	}
+
+	// Changing the returned copy mustn't change the stored snippet.
+	got.Title = "Changed"
+	if again, _ := m.GetSnippet(s.ID, 0); again.Title != s.Title {
+		t.Errorf("stored title changed to %q", again.Title)
+	}
+}
+
+func TestMemoryStoreUserSnippets(t *testing.T) {
+	m := NewMemoryStore()
+	first := insert(t, m, 1, Public)
+	insert(t, m, 2, Public)
+	insert(t, m, 0, Public)
+	expired := &Snippet{UserID: 1, Title: "Expired", Content: "Gone", Expires: time.Now().UTC().Add(-time.Minute), Visibility: Public}
+	if err := m.InsertSnippet(expired); err != nil {
+		t.Fatalf("InsertSnippet() returned %s", err)
+	}
+	second := insert(t, m, 1, Private)
+
+	mine, err := m.UserSnippets(1)
+	if err != nil {
+		t.Fatalf("UserSnippets() returned %s", err)
+	}
+	if len(mine) != 2 || mine[0].ID != second.ID || mine[1].ID != first.ID {
+		t.Errorf("UserSnippets() returned %d snippets; want the two live ones, newest first", len(mine))
+	}
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	m := NewMemoryStore()
+	live := insert(t, m, 0, Public)
+	expired := &Snippet{
+		Title:      "Expired",
+		Content:    "Gone",
+		Expires:    time.Now().UTC().Add(-time.Minute),
+		Visibility: Public,
+	}
+	if err := m.InsertSnippet(expired); err != nil {
+		t.Fatalf("InsertSnippet() returned %s", err)
+	}
+
+	if s, _ := m.GetSnippet(expired.ID, 0); s != nil {
+		t.Error("GetSnippet() returned an expired snippet")
+	}
+	if s, _ := m.GetSnippetBySlug(expired.Slug, 0); s != nil {
+		t.Error("GetSnippetBySlug() returned an expired snippet")
+	}
+	latest, _ := m.LatestSnippets()
+	if len(latest) != 1 || latest[0].ID != live.ID {
+		t.Errorf("LatestSnippets() returned %d snippets; want only the live one", len(latest))
+	}
+
+	n, err := m.DeleteExpiredSnippets(time.Now().UTC(), 100)
+	if err != nil || n != 1 {
+		t.Errorf("DeleteExpiredSnippets() = %d, %v; want 1, nil", n, err)
+	}
+	if s, _ := m.GetSnippet(live.ID, 0); s == nil {
+		t.Error("DeleteExpiredSnippets() deleted a live snippet")
+	}
+}
+
+func TestMemoryStoreUsers(t *testing.T) {
+	m := NewMemoryStore()
+	if err := m.InsertUser("Alice", "alice@example.com", "password123"); err != nil {
+		t.Fatalf("InsertUser() returned %s", err)
+	}
+	if err := m.InsertUser("Alice", "alice@example.com", "password456"); err != ErrDuplicateEmail {
+		t.Errorf("InsertUser() with a taken email returned %v; want ErrDuplicateEmail", err)
+	}
+
+	if id, err := m.VerifyUser("alice@example.com", "password123"); id != 1 || err != nil {
+		t.Errorf("VerifyUser() = %d, %v; want 1, nil", id, err)
+	}
+	for _, login := range [][2]string{{"alice@example.com", "password456"}, {"bob@example.com", "password123"}} {
+		if _, err := m.VerifyUser(login[0], login[1]); err != ErrInvalidCredentials {
+			t.Errorf("VerifyUser(%q, %q) returned %v; want ErrInvalidCredentials", login[0], login[1], err)
+		}
+	}
+}
+
+func TestMemoryStoreVisibility(t *testing.T) {
+	m := NewMemoryStore()
+	public := insert(t, m, 1, Public)
+	unlisted := insert(t, m, 1, Unlisted)
+	private := insert(t, m, 1, Private)
+
+	tests := []struct {
+		name     string
+		snippet  *Snippet
+		viewerID int
+		byID     bool
+		bySlug   bool
+	}{
+		{"public to anyone", public, 0, true, true},
+		{"unlisted to anyone", unlisted, 0, false, true},
+		{"private to anyone", private, 0, false, false},
+		{"private to another user", private, 2, false, false},
+		{"unlisted to owner", unlisted, 1, true, true},
+		{"private to owner", private, 1, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := m.GetSnippet(tt.snippet.ID, tt.viewerID)
+			if err != nil {
+				t.Fatalf("GetSnippet() returned %s", err)
+			}
+			if (s != nil) != tt.byID {
+				t.Errorf("GetSnippet() found it: %t; want %t", s != nil, tt.byID)
+			}
+			s, err = m.GetSnippetBySlug(tt.snippet.Slug, tt.viewerID)
+			if err != nil {
+				t.Fatalf("GetSnippetBySlug() returned %s", err)
+			}
+			if (s != nil) != tt.bySlug {
+				t.Errorf("GetSnippetBySlug() found it: %t; want %t", s != nil, tt.bySlug)
+			}
+		})
+	}
+
+	// Only the public snippet is listed, even for its owner.
+	latest, _ := m.LatestSnippets()
+	if len(latest) != 1 || latest[0].ID != public.ID {
+		t.Errorf("LatestSnippets() returned %d snippets; want only the public one", len(latest))
+	}
+	mine, _ := m.UserSnippets(1)
+	if len(mine) != 3 {
+		t.Errorf("UserSnippets() returned %d snippets; want 3", len(mine))
+	}
+}
+
+func TestMemoryStoreBurnSnippet(t *testing.T) {
+	m := NewMemoryStore()
+	s := &Snippet{
+		Title:      "Secret",
+		Content:    "Read me once",
+		Expires:    time.Now().UTC().Add(time.Hour),
+		Burn:       true,
+		Visibility: Unlisted,
+	}
+	if err := m.InsertSnippet(s); err != nil {
+		t.Fatalf("InsertSnippet() returned %s", err)
+	}
+	if burned, _ := m.SnippetBurned(s.Slug); burned {
+		t.Fatal("SnippetBurned() is true before the snippet was burned")
+	}
+
+	burned, err := m.BurnSnippet(s)
+	if err != nil || !burned {
+		t.Fatalf("BurnSnippet() = %t, %v; want true, nil", burned, err)
+	}
+	if got, _ := m.GetSnippetBySlug(s.Slug, 0); got != nil {
+		t.Error("the snippet can still be read after it was burned")
+	}
+	if burned, _ := m.SnippetBurned(s.Slug); !burned {
+		t.Error("SnippetBurned() is false after the snippet was burned")
+	}
+
+	// Only one of two concurrent readers can burn the snippet.
+	if burned, _ := m.BurnSnippet(s); burned {
+		t.Error("BurnSnippet() burned the snippet twice")
+	}
+
+	// Snippets without Burn set are never burned.
+	kept := insert(t, m, 0, Public)
+	if burned, _ := m.BurnSnippet(kept); burned {
+		t.Error("BurnSnippet() burned a snippet without Burn set")
+	}
+
+	// The tombstone is kept until it's older than the retention period.
+	if n, err := m.DeleteBurnedSnippets(time.Now().UTC().Add(-time.Hour), 100); n != 0 || err != nil {
+		t.Errorf("DeleteBurnedSnippets() of older tombstones = %d, %v; want 0, nil", n, err)
+	}
+	if n, err := m.DeleteBurnedSnippets(time.Now().UTC().Add(time.Minute), 100); n != 1 || err != nil {
+		t.Errorf("DeleteBurnedSnippets() = %d, %v; want 1, nil", n, err)
+	}
+	if burned, _ := m.SnippetBurned(s.Slug); burned {
+		t.Error("SnippetBurned() is still true after the tombstone was deleted")
+	}
+}
+
+func TestMemoryStorePageSnippets(t *testing.T) {
+	m := NewMemoryStore()
+	for i := 0; i < 25; i++ {
+		insert(t, m, 0, Public)
+	}
+	// Unlisted snippets are never listed.
+	insert(t, m, 0, Unlisted)
+
+	ids := func(snippets Snippets) (first, last int) {
+		return snippets[0].ID, snippets[len(snippets)-1].ID
+	}
+
+	page1, more, err := m.PageSnippets(SnippetFilter{Limit: 10})
+	if err != nil {
+		t.Fatalf("PageSnippets() returned %s", err)
+	}
+	if first, last := ids(page1); len(page1) != 10 || first != 25 || last != 16 || !more {
+		t.Fatalf("first page has %d snippets, %d to %d, more %t; want 10, 25 to 16, true", len(page1), first, last, more)
+	}
+
+	page2, more, _ := m.PageSnippets(SnippetFilter{Before: CursorOf(page1[9]), Limit: 10})
+	if first, last := ids(page2); len(page2) != 10 || first != 15 || last != 6 || !more {
+		t.Fatalf("second page has %d snippets, %d to %d, more %t; want 10, 15 to 6, true", len(page2), first, last, more)
+	}
+
+	page3, more, _ := m.PageSnippets(SnippetFilter{Before: CursorOf(page2[9]), Limit: 10})
+	if first, last := ids(page3); len(page3) != 5 || first != 5 || last != 1 || more {
+		t.Fatalf("last page has %d snippets, %d to %d, more %t; want 5, 5 to 1, false", len(page3), first, last, more)
+	}
+
+	// Paging forwards from the second page gets back to the first, still
+	// newest first.
+	back, more, _ := m.PageSnippets(SnippetFilter{After: CursorOf(page2[0]), Limit: 10})
+	if first, last := ids(back); len(back) != 10 || first != 25 || last != 16 || more {
+		t.Errorf("newer page has %d snippets, %d to %d, more %t; want 10, 25 to 16, false", len(back), first, last, more)
+	}
+}
+
+// The testPageTies() helper pages through five snippets which were all
+// created at the same instant, two at a time, and checks that their IDs break
+// the tie so that no snippet is skipped or repeated in either direction. The
+// IDs are given in the order the snippets were inserted.
+func testPageTies(t *testing.T, ids []int, pageSnippets func(SnippetFilter) (Snippets, bool, error)) {
+	t.Helper()
+	join := func(ids ...int) string {
+		var s []string
+		for _, id := range ids {
+			s = append(s, fmt.Sprint(id))
+		}
+		return strings.Join(s, ",")
+	}
+	check := func(f SnippetFilter, want string, wantMore bool) Snippets {
+		t.Helper()
+		page, more, err := pageSnippets(f)
+		if err != nil {
+			t.Fatalf("PageSnippets() returned %s", err)
+		}
+		var got []int
+		for _, s := range page {
+			got = append(got, s.ID)
+		}
+		if join(got...) != want || more != wantMore {
+			t.Fatalf("page is %s, more %t; want %s, %t", join(got...), more, want, wantMore)
+		}
+		return page
+	}
+
+	page1 := check(SnippetFilter{Limit: 2}, join(ids[4], ids[3]), true)
+	page2 := check(SnippetFilter{Before: CursorOf(page1[1]), Limit: 2}, join(ids[2], ids[1]), true)
+	page3 := check(SnippetFilter{Before: CursorOf(page2[1]), Limit: 2}, join(ids[0]), false)
+
+	// And back again.
+	page2 = check(SnippetFilter{After: CursorOf(page3[0]), Limit: 2}, join(ids[2], ids[1]), true)
+	check(SnippetFilter{After: CursorOf(page2[0]), Limit: 2}, join(ids[4], ids[3]), false)
+}
+
+func TestMemoryStorePageSnippetsTies(t *testing.T) {
+	m := NewMemoryStore()
+	created := time.Now().UTC().Add(-time.Minute)
+	var ids []int
+	for i := 0; i < 5; i++ {
+		s := insert(t, m, 0, Public)
+		m.snippets[s.ID].Created = created
+		ids = append(ids, s.ID)
+	}
+	testPageTies(t, ids, m.PageSnippets)
+}
+
+func TestMemoryStoreSearchSnippets(t *testing.T) {
+	m := NewMemoryStore()
+	add := func(s *Snippet) *Snippet {
+		t.Helper()
+		if s.Expires.IsZero() {
+			s.Expires = time.Now().UTC().Add(time.Hour)
+		}
+		if s.Visibility == "" {
+			s.Visibility = Public
+		}
+		if err := m.InsertSnippet(s); err != nil {
+			t.Fatalf("InsertSnippet() returned %s", err)
+		}
+		return s
+	}
+	both := add(&Snippet{Title: "Haiku", Content: "An old silent pond"})
+	inTitle := add(&Snippet{Title: "Silent pond", Content: "A frog jumps in"})
+	add(&Snippet{Title: "Haiku", Content: "A silent night"})
+	add(&Snippet{Title: "Haiku", Content: "The pond is still"})
+
+	// Snippets which can't be listed are never found.
+	protected := &Snippet{Title: "Silent pond", Content: "Protected"}
+	if err := protected.SetPassword("hunter22"); err != nil {
+		t.Fatal(err)
+	}
+	add(protected)
+	add(&Snippet{UserID: 1, Title: "Silent pond", Content: "Private", Visibility: Private})
+	add(&Snippet{Title: "Silent pond", Content: "Unlisted", Visibility: Unlisted})
+	add(&Snippet{Title: "Silent pond", Content: "Burn", Burn: true})
+	add(&Snippet{Title: "Silent pond", Content: "Expired", Expires: time.Now().UTC().Add(-time.Minute)})
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		// Every term has to match, in either the title or the content, and
+		// title matches rank higher.
+		{"silent pond", []int{inTitle.ID, both.ID}},
+		{"SILENT  Pond", []int{inTitle.ID, both.ID}},
+		{"silent pond frog", []int{inTitle.ID}},
+		{"silent toad", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		snippets, more, err := m.SearchSnippets(tt.query, 1)
+		if err != nil {
+			t.Fatalf("SearchSnippets(%q) returned %s", tt.query, err)
+		}
+		var got []int
+		for _, s := range snippets {
+			got = append(got, s.ID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) || more {
+			t.Errorf("SearchSnippets(%q) = %v, %t; want %v, false", tt.query, got, more, tt.want)
+		}
+	}
+}
+
+func TestMemoryStoreSearchSnippetsPages(t *testing.T) {
+	m := NewMemoryStore()
+	for i := 0; i < SearchPageSize+2; i++ {
+		insert(t, m, 0, Public)
+	}
+
+	page1, more, err := m.SearchSnippets("silent pond", 1)
+	if err != nil || len(page1) != SearchPageSize || !more {
+		t.Fatalf("first page = %d snippets, %t, %v; want %d, true, nil", len(page1), more, err, SearchPageSize)
+	}
+	page2, more, err := m.SearchSnippets("silent pond", 2)
+	if err != nil || len(page2) != 2 || more {
+		t.Fatalf("second page = %d snippets, %t, %v; want 2, false, nil", len(page2), more, err)
+	}
+	seen := map[int]bool{}
+	for _, s := range append(page1, page2...) {
+		if seen[s.ID] {
+			t.Errorf("snippet %d is on both pages", s.ID)
+		}
+		seen[s.ID] = true
+	}
+	if page3, more, _ := m.SearchSnippets("silent pond", 3); len(page3) != 0 || more {
+		t.Errorf("third page = %d snippets, %t; want none", len(page3), more)
+	}
+}
@@ -1,17 +1,715 @@
 package models
 
 import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"math/big"
+	"sinistra/snippetbox/pkg/keyring"
+	"strconv"
+	"strings"
 	"time"
 )
 
 // Define a Snippet type to hold the information about an individual snippet.
+// The UserID is the ID of the user who created the snippet, and Author is
+// their name. Both are zero values for snippets created before snippets had
+// owners. Burn is set for "burn after reading" snippets, which are deleted the
+// first time someone other than their owner views them. Visibility is one of
+// Public, Unlisted or Private, and Slug is the random identifier used in the
+// snippet's URL. HashedPassword is the bcrypt hash of the password needed to
+// view a password-protected snippet, or empty if there isn't one. Encrypted
+// is set for snippets whose Content was encrypted in the browser; the server
+// only ever sees the ciphertext, and never the key. Language is the name of
+// the language used to highlight the content, or empty to detect it.
 type Snippet struct {
-	ID      int
-	Title   string
-	Content string
-	Created time.Time
-	Expires time.Time
+	ID             int
+	UserID         int
+	Author         string
+	Title          string
+	Content        string
+	Created        time.Time
+	Expires        time.Time
+	Burn           bool
+	Visibility     string
+	Slug           string
+	HashedPassword []byte
+	Encrypted      bool
+	Language       string
+}
+
+// The visibility values. Public snippets appear in the listings and can be
+// viewed by anyone. Unlisted snippets can be viewed by anyone who has the link
+// containing their slug, but aren't listed anywhere. Private snippets can only
+// be viewed by their owner.
+const (
+	Public   = "public"
+	Unlisted = "unlisted"
+	Private  = "private"
+)
+
+// SetPassword sets the password needed to view the snippet, storing a bcrypt
+// hash of it in the same way as InsertUser() does for users. An empty password
+// removes the protection.
+func (s *Snippet) SetPassword(password string) error {
+	if password == "" {
+		s.HashedPassword = nil
+		return nil
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+	s.HashedPassword = hashedPassword
+	return nil
+}
+
+// Protected reports whether a password is needed to view the snippet.
+func (s *Snippet) Protected() bool {
+	return len(s.HashedPassword) > 0
+}
+
+// CheckPassword returns ErrInvalidCredentials if the password doesn't match
+// the snippet's password.
+func (s *Snippet) CheckPassword(password string) error {
+	err := bcrypt.CompareHashAndPassword(s.HashedPassword, []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrInvalidCredentials
+	}
+	return err
+}
+
+// Path returns the URL path of the snippet's page. Every snippet is given a
+// slug when it's created, so the sequential ID is only used as a fallback.
+func (s *Snippet) Path() string {
+	if s.Slug != "" {
+		return "/snippet/" + s.Slug
+	}
+	return "/snippet/" + strconv.Itoa(s.ID)
+}
+
+// Never is the expiry time stored for snippets which never expire. It's the
+// latest time that a MySQL DATETIME column can hold, so that the existing
+// expiry checks work unchanged.
+var Never = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
+// NeverExpires reports whether the snippet has no expiry time.
+func (s *Snippet) NeverExpires() bool {
+	return !s.Expires.Before(Never)
 }
 
 // For convenience we also define a Snippets type, which is a slice for holding multiple Snippet objects.
 type Snippets []*Snippet
+
+// Define a Revision type to hold one version of a snippet's title and content.
+// Revisions are numbered from 1 for each snippet.
+type Revision struct {
+	SnippetID int
+	Number    int
+	Title     string
+	Content   string
+	Created   time.Time
+}
+
+// Cursor identifies a position in the list of snippets ordered by creation
+// time, with the ID used to break ties between snippets created at the same
+// instant. This is the same order as the idx_snippets_created_id index, so
+// the database can seek straight to the cursor rather than counting through
+// an OFFSET.
+type Cursor struct {
+	Created time.Time
+	ID      int
+}
+
+// ErrInvalidCursor is returned by ParseCursor() for malformed cursor strings.
+var ErrInvalidCursor = errors.New("models: invalid cursor")
+
+// String encodes the cursor for use in a URL query string.
+func (c *Cursor) String() string {
+	return fmt.Sprintf("%d-%d", c.Created.UnixNano(), c.ID)
+}
+
+// ParseCursor decodes a cursor created by the String() method.
+func ParseCursor(s string) (*Cursor, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil || id < 1 {
+		return nil, ErrInvalidCursor
+	}
+	return &Cursor{Created: time.Unix(0, nanos).UTC(), ID: id}, nil
+}
+
+// CursorOf returns the cursor pointing at a snippet.
+func CursorOf(s *Snippet) *Cursor {
+	return &Cursor{Created: s.Created, ID: s.ID}
+}
+
+// SnippetFilter holds the options for PageSnippets(). At most one of Before
+// and After should be set. From and To restrict the results to snippets
+// created in the range [From, To), and are ignored when they're zero.
+type SnippetFilter struct {
+	Before *Cursor
+	After  *Cursor
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+// SearchPageSize is the number of results on each page of SearchSnippets().
+const SearchPageSize = 10
+
+// SearchTerms splits a search query into the words to look for. The number of
+// terms is capped so that a long query can't produce an enormous statement.
+func SearchTerms(query string) []string {
+	terms := strings.Fields(query)
+	if len(terms) > 10 {
+		terms = terms[:10]
+	}
+	return terms
+}
+
+// The SQL backends all select the same snippet columns, joined to the users
+// table so that we can display the author's name. COALESCE() is used so that
+// snippets without an owner scan cleanly into the int and string fields. The
+// key_id and wrapped_key columns are empty unless the content is encrypted at
+// rest, and the revisionColumns likewise for the snippet_revisions table.
+const (
+	snippetColumns = `s.id, COALESCE(s.user_id, 0), COALESCE(u.name, ''), s.title, s.content, s.created, s.expires, s.burn,
+s.visibility, s.slug, COALESCE(s.password, ''), s.encrypted, COALESCE(s.key_id, ''), COALESCE(s.wrapped_key, ''), s.language`
+	snippetTables   = `snippets s LEFT JOIN users u ON u.id = s.user_id`
+	revisionColumns = `snippet_id, revision, title, content, created, COALESCE(key_id, ''), COALESCE(wrapped_key, '')`
+)
+
+// The listedSnippet condition restricts the public listings (the home page,
+// archive and search results) to snippets which may be shown there. Burn after
+// reading snippets are only meant for whoever is given the link, as are
+// password-protected snippets, and only public snippets are listed at all.
+// Encrypted snippets are skipped too, as there's nothing useful that the
+// server can show or search in their content.
+const listedSnippet = `s.burn = FALSE AND s.password IS NULL AND s.encrypted = FALSE AND s.visibility = 'public'`
+
+// The viewableByID() and viewableBySlug() helpers return the conditions which
+// decide whether the viewer, whose ID is bound to the given placeholder, may
+// see a snippet looked up by its ID or its slug. Only public snippets can be
+// found by ID, as the IDs are easy to guess, but an unlisted snippet can be
+// found by anyone who knows its slug. The owner can always see their own
+// snippets. A viewer ID of 0 never matches, because s.user_id is either NULL
+// or a real user's ID.
+func viewableByID(placeholder string) string {
+	return "(s.visibility = 'public' OR s.user_id = " + placeholder + ")"
+}
+
+func viewableBySlug(placeholder string) string {
+	return "(s.visibility <> 'private' OR s.user_id = " + placeholder + ")"
+}
+
+// The slug alphabet is base62, so slugs can be used in URLs without escaping.
+const (
+	slugAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	slugLength   = 10
+	slugAttempts = 5
+)
+
+// NewSlug returns a random slug from the crypto/rand generator. Ten base62
+// characters give almost 60 bits of randomness, which is far too many to
+// enumerate. Slugs made entirely of digits are rejected, so that a slug can
+// always be told apart from a numeric ID.
+func NewSlug() (string, error) {
+	max := big.NewInt(int64(len(slugAlphabet)))
+	for {
+		b := make([]byte, slugLength)
+		digits := true
+		for i := range b {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return "", err
+			}
+			b[i] = slugAlphabet[n.Int64()]
+			digits = digits && n.Int64() < 10
+		}
+		if !digits {
+			return string(b), nil
+		}
+	}
+}
+
+// The withSlug() helper gives a new snippet a random slug and calls insert to
+// store it. If the insert fails because the slug is already taken (which the
+// duplicate function detects) it's retried with a different slug a few times.
+func withSlug(s *Snippet, insert func() error, duplicate func(error) bool) error {
+	for i := 1; ; i++ {
+		slug, err := NewSlug()
+		if err != nil {
+			return err
+		}
+		s.Slug = slug
+		err = insert()
+		if err == nil {
+			return nil
+		}
+		s.Slug = ""
+		if i == slugAttempts || !duplicate(err) {
+			return err
+		}
+	}
+}
+
+// The scanner interface is satisfied by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// The querier interface is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// The scanSnippet() helper copies the snippetColumns from a row into a new
+// Snippet, decrypting the content with the keyring if it was encrypted at rest.
+// If the row doesn't exist it returns nil, in the same way as GetSnippet().
+func scanSnippet(keys *keyring.Keyring, row scanner) (*Snippet, error) {
+	s := &Snippet{}
+	var keyID, wrappedKey string
+	err := row.Scan(&s.ID, &s.UserID, &s.Author, &s.Title, &s.Content, &s.Created, &s.Expires, &s.Burn,
+		&s.Visibility, &s.Slug, &s.HashedPassword, &s.Encrypted, &keyID, &wrappedKey, &s.Language)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	s.Content, err = openContent(keys, s.Content, keyID, wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// The querySnippets() helper executes a statement which selects the
+// snippetColumns and returns the results as a Snippets slice.
+func querySnippets(keys *keyring.Keyring, q querier, stmt string, args ...interface{}) (Snippets, error) {
+	rows, err := q.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	// Closing a resultset is really important. As long as a resultset is open
+	// it will keep the underlying database connection open.
+	defer rows.Close()
+
+	snippets := Snippets{}
+	for rows.Next() {
+		s, err := scanSnippet(keys, rows)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+	// Don't assume that a successful iteration was completed over the whole
+	// resultset.
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return snippets, nil
+}
+
+// The queryRevisions() helper executes a statement which selects the
+// revisionColumns from the snippet_revisions table.
+func queryRevisions(keys *keyring.Keyring, q querier, stmt string, args ...interface{}) ([]*Revision, error) {
+	rows, err := q.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*Revision{}
+	for rows.Next() {
+		r := &Revision{}
+		var keyID, wrappedKey string
+		err := rows.Scan(&r.SnippetID, &r.Number, &r.Title, &r.Content, &r.Created, &keyID, &wrappedKey)
+		if err != nil {
+			return nil, err
+		}
+		r.Content, err = openContent(keys, r.Content, keyID, wrappedKey)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// The pageQuery() helper builds the statement for PageSnippets(), adding the
+// filter conditions to those given by the caller. The placeholder function
+// returns the bind parameter for the nth argument, which lets us share the
+// code between backends that use ? and $n placeholders. One more row than the
+// limit is selected so that pageResults() can tell whether there are more.
+func pageQuery(f SnippetFilter, conds []string, args []interface{}, placeholder func(n int) string) (string, []interface{}) {
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return placeholder(len(args))
+	}
+	if !f.From.IsZero() {
+		conds = append(conds, "s.created >= "+arg(f.From))
+	}
+	if !f.To.IsZero() {
+		conds = append(conds, "s.created < "+arg(f.To))
+	}
+	// When paging forwards to newer snippets we have to walk the index in
+	// ascending order, and pageResults() reverses the rows afterwards.
+	order := "DESC"
+	if f.After != nil {
+		conds = append(conds, fmt.Sprintf("(s.created > %s OR (s.created = %s AND s.id > %s))",
+			arg(f.After.Created), arg(f.After.Created), arg(f.After.ID)))
+		order = "ASC"
+	} else if f.Before != nil {
+		conds = append(conds, fmt.Sprintf("(s.created < %s OR (s.created = %s AND s.id < %s))",
+			arg(f.Before.Created), arg(f.Before.Created), arg(f.Before.ID)))
+	}
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+WHERE ` + strings.Join(conds, " AND ") + `
+ORDER BY s.created ` + order + `, s.id ` + order + ` LIMIT ` + arg(f.Limit+1)
+	return stmt, args
+}
+
+// The pageResults() helper trims the extra row selected by pageQuery(), and
+// reports whether there are more snippets beyond this page in the direction
+// we're paging. The snippets are always returned newest first.
+func pageResults(snippets Snippets, f SnippetFilter) (Snippets, bool) {
+	more := len(snippets) > f.Limit
+	if more {
+		snippets = snippets[:f.Limit]
+	}
+	if f.After != nil {
+		for i, j := 0, len(snippets)-1; i < j; i, j = i+1, j-1 {
+			snippets[i], snippets[j] = snippets[j], snippets[i]
+		}
+	}
+	return snippets, more
+}
+
+// The searchOffset() helper returns the OFFSET for a 1-based search results
+// page.
+func searchOffset(page int) int {
+	if page < 1 {
+		page = 1
+	}
+	return (page - 1) * SearchPageSize
+}
+
+// The searchResults() helper trims the extra row selected by the search
+// queries and reports whether there's another page of results.
+func searchResults(snippets Snippets) (Snippets, bool) {
+	if len(snippets) > SearchPageSize {
+		return snippets[:SearchPageSize], true
+	}
+	return snippets, false
+}
+
+// The likePattern() helper escapes the LIKE wildcards in a search term, and
+// wraps it in % so that it matches anywhere in a column.
+func likePattern(term string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + r.Replace(term) + "%"
+}
+
+// The deleteExpired() helper deletes up to limit snippets which expired before
+// the given time, along with their revisions, and returns how many were
+// deleted. The IDs are selected first so that the same statements work in all
+// our SQL backends (MySQL doesn't support LIMIT in an IN subquery).
+func deleteExpired(db *sql.DB, before time.Time, limit int, placeholder func(n int) string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	stmt := fmt.Sprintf("SELECT id FROM snippets WHERE expires < %s ORDER BY expires LIMIT %s",
+		placeholder(1), placeholder(2))
+	rows, err := tx.Query(stmt, before, limit)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	var ids []interface{}
+	var marks []string
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return 0, err
+		}
+		ids = append(ids, id)
+		marks = append(marks, placeholder(len(ids)))
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, tx.Commit()
+	}
+
+	in := strings.Join(marks, ", ")
+	if _, err = tx.Exec("DELETE FROM snippet_revisions WHERE snippet_id IN ("+in+")", ids...); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if _, err = tx.Exec("DELETE FROM snippets WHERE id IN ("+in+")", ids...); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// The burnSnippet() helper atomically deletes a burn after reading snippet and
+// its revisions, and records a tombstone in the burned_snippets table so that
+// later visitors to its URL can be told what happened. It returns false if the
+// snippet had already been burned, for example by a concurrent request.
+func burnSnippet(db *sql.DB, s *Snippet, placeholder func(n int) string) (bool, error) {
+	id := s.ID
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	// Only one DELETE can affect the row, so whichever request gets here first
+	// wins and every other request sees zero rows affected.
+	result, err := tx.Exec("DELETE FROM snippets WHERE burn = TRUE AND id = "+placeholder(1), id)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		tx.Rollback()
+		return false, err
+	}
+	if _, err = tx.Exec("DELETE FROM snippet_revisions WHERE snippet_id = "+placeholder(1), id); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	stmt := fmt.Sprintf("INSERT INTO burned_snippets (snippet_id, slug, burned) VALUES(%s, %s, %s)",
+		placeholder(1), placeholder(2), placeholder(3))
+	if _, err = tx.Exec(stmt, id, s.Slug, time.Now().UTC()); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// The deleteBurned() helper deletes up to limit tombstones of snippets which
+// were burned before the given time, and returns how many were deleted. Like
+// deleteExpired(), it selects the IDs first so that the statements work in all
+// our SQL backends.
+func deleteBurned(db *sql.DB, before time.Time, limit int, placeholder func(n int) string) (int, error) {
+	stmt := fmt.Sprintf("SELECT snippet_id FROM burned_snippets WHERE burned < %s ORDER BY burned LIMIT %s",
+		placeholder(1), placeholder(2))
+	rows, err := db.Query(stmt, before, limit)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var ids []interface{}
+	var marks []string
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+		marks = append(marks, placeholder(len(ids)))
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	result, err := db.Exec("DELETE FROM burned_snippets WHERE snippet_id IN ("+strings.Join(marks, ", ")+")", ids...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// The snippetBurned() helper reports whether the snippet with the given slug
+// has been burned.
+func snippetBurned(db *sql.DB, slug string, placeholder func(n int) string) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM burned_snippets WHERE slug = "+placeholder(1), slug).Scan(&n)
+	return n > 0, err
+}
+
+// The questionMark() and dollar() functions generate MySQL/SQLite and
+// PostgreSQL style placeholders for pageQuery().
+func questionMark(n int) string {
+	return "?"
+}
+
+func dollar(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// The nullInt() helper converts an ID into a value for a nullable column,
+// storing NULL for the zero value.
+func nullInt(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// The nullBytes() helper does the same for a hashed password, storing NULL if
+// it's empty.
+func nullBytes(b []byte) sql.NullString {
+	return sql.NullString{String: string(b), Valid: len(b) > 0}
+}
+
+// ErrNoKeyring is returned when a row's content was encrypted at rest, but
+// the store wasn't given a keyring to decrypt it with.
+var ErrNoKeyring = errors.New("models: content is encrypted but no master key was loaded")
+
+// The sealed type holds the values for the content, key_id and wrapped_key
+// columns. Encrypted content is stored as base64, so that it fits in the
+// existing text columns.
+type sealed struct {
+	content    string
+	keyID      sql.NullString
+	wrappedKey sql.NullString
+}
+
+// The sealContent() helper encrypts content at rest with the keyring. If the
+// store has no keyring the content is stored as it is, with NULL key columns.
+func sealContent(keys *keyring.Keyring, content string) (*sealed, error) {
+	if keys == nil {
+		return &sealed{content: content}, nil
+	}
+	e, err := keys.Seal([]byte(content))
+	if err != nil {
+		return nil, err
+	}
+	return &sealed{
+		content:    base64.StdEncoding.EncodeToString(e.Ciphertext),
+		keyID:      sql.NullString{String: e.KeyID, Valid: true},
+		wrappedKey: sql.NullString{String: base64.StdEncoding.EncodeToString(e.WrappedKey), Valid: true},
+	}, nil
+}
+
+// The openContent() helper reverses sealContent(). Rows with an empty key ID
+// were stored before encryption was enabled, and are returned unchanged.
+func openContent(keys *keyring.Keyring, content, keyID, wrappedKey string) (string, error) {
+	if keyID == "" {
+		return content, nil
+	}
+	if keys == nil {
+		return "", ErrNoKeyring
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(wrappedKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := keys.Open(&keyring.Envelope{KeyID: keyID, WrappedKey: wrapped, Ciphertext: ciphertext})
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// The reencrypt() helper seals the content of up to batch rows in the table
+// (snippets or snippet_revisions) which aren't sealed with the keyring's
+// current key, including rows stored before encryption was enabled, and
+// returns the number of rows changed. Each row is only updated if its content
+// hasn't changed since it was read, so that a concurrent edit is never
+// overwritten with the old content; an edited row is sealed with the current
+// key anyway.
+func reencrypt(db *sql.DB, keys *keyring.Keyring, table string, batch int, placeholder func(int) string) (int, error) {
+	if keys == nil {
+		return 0, ErrNoKeyring
+	}
+
+	stmt := fmt.Sprintf(`SELECT id, content, COALESCE(key_id, ''), COALESCE(wrapped_key, '') FROM %s
+WHERE key_id IS NULL OR key_id <> %s ORDER BY id LIMIT %s`, table, placeholder(1), placeholder(2))
+	rows, err := db.Query(stmt, keys.Current(), batch)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	type row struct {
+		id                         int
+		content, keyID, wrappedKey string
+	}
+	stale := []row{}
+	for rows.Next() {
+		var r row
+		if err = rows.Scan(&r.id, &r.content, &r.keyID, &r.wrappedKey); err != nil {
+			return 0, err
+		}
+		stale = append(stale, r)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	stmt = fmt.Sprintf(`UPDATE %s SET content = %s, key_id = %s, wrapped_key = %s WHERE id = %s AND content = %s`,
+		table, placeholder(1), placeholder(2), placeholder(3), placeholder(4), placeholder(5))
+	n := 0
+	for _, r := range stale {
+		plaintext, err := openContent(keys, r.content, r.keyID, r.wrappedKey)
+		if err != nil {
+			return n, fmt.Errorf("models: %s row %d: %v", table, r.id, err)
+		}
+		c, err := sealContent(keys, plaintext)
+		if err != nil {
+			return n, err
+		}
+		result, err := db.Exec(stmt, c.content, c.keyID, c.wrappedKey, r.id, r.content)
+		if err != nil {
+			return n, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return n, err
+		}
+		n += int(affected)
+	}
+	return n, nil
+}
+
+// The reencryptAll() helper calls reencrypt() for the snippets and their
+// revisions until there's nothing left to do, and returns the total number of
+// rows changed.
+func reencryptAll(db *sql.DB, keys *keyring.Keyring, batch int, placeholder func(int) string) (int, error) {
+	total := 0
+	for _, table := range []string{"snippets", "snippet_revisions"} {
+		for {
+			n, err := reencrypt(db, keys, table, batch, placeholder)
+			total += n
+			if err != nil {
+				return total, err
+			}
+			if n == 0 {
+				break
+			}
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCursor(t *testing.T) {
+	c := &Cursor{Created: time.Date(2019, 10, 7, 9, 0, 0, 123456789, time.UTC), ID: 42}
+	got, err := ParseCursor(c.String())
+	if err != nil {
+		t.Fatalf("ParseCursor(%q) returned %s", c.String(), err)
+	}
+	if !got.Created.Equal(c.Created) || got.ID != c.ID {
+		t.Errorf("ParseCursor(%q) = %+v; want %+v", c.String(), got, c)
+	}
+
+	for _, s := range []string{
+		"",
+		"nonsense",
+		"1570438800000000000",
+		"1570438800000000000-",
+		"-42",
+		"x-42",
+		"1570438800000000000-x",
+		"1570438800000000000-0",
+		"1570438800000000000--42",
+		"99999999999999999999-42",
+		"1570438800000000000-42-1",
+	} {
+		if _, err := ParseCursor(s); err != ErrInvalidCursor {
+			t.Errorf("ParseCursor(%q) returned %v; want ErrInvalidCursor", s, err)
+		}
+	}
+}
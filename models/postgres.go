@@ -0,0 +1,274 @@
+package models
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+	"sinistra/snippetbox/pkg/keyring"
+	"time"
+)
+
+// PostgresDatabase is an implementation of the Store interface backed by a
+// PostgreSQL database. PostgreSQL uses numbered $n placeholders rather than ?,
+// and its driver doesn't support LastInsertId(), so the insert statements use
+// a RETURNING clause to get the ID of the new record instead. As with the
+// MySQL Database type, content is encrypted at rest if Keys is set.
+type PostgresDatabase struct {
+	*sql.DB
+	Keys *keyring.Keyring
+}
+
+// Check at compile time that the PostgresDatabase type satisfies the Store interface.
+var _ Store = &PostgresDatabase{}
+
+// PostgreSQL reports a violation of a UNIQUE constraint with SQLSTATE 23505.
+const pqUniqueViolation = pq.ErrorCode("23505")
+
+// The postgresDuplicate() function reports whether an error is a violation of
+// a UNIQUE constraint.
+func postgresDuplicate(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == pqUniqueViolation
+}
+
+func (db *PostgresDatabase) GetSnippet(id, viewerID int) (*Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+WHERE s.expires > $1 AND s.id = $2 AND ` + viewableByID("$3")
+	return scanSnippet(db.Keys, db.QueryRow(stmt, time.Now().UTC(), id, viewerID))
+}
+
+func (db *PostgresDatabase) GetSnippetBySlug(slug string, viewerID int) (*Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+WHERE s.expires > $1 AND s.slug = $2 AND ` + viewableBySlug("$3")
+	return scanSnippet(db.Keys, db.QueryRow(stmt, time.Now().UTC(), slug, viewerID))
+}
+
+func (db *PostgresDatabase) LatestSnippets() (Snippets, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+WHERE s.expires > $1 AND ` + listedSnippet + ` ORDER BY s.created DESC LIMIT 10`
+	return querySnippets(db.Keys, db, stmt, time.Now().UTC())
+}
+
+func (db *PostgresDatabase) PageSnippets(f SnippetFilter) (Snippets, bool, error) {
+	stmt, args := pageQuery(f, []string{"s.expires > $1", listedSnippet}, []interface{}{time.Now().UTC()}, dollar)
+	snippets, err := querySnippets(db.Keys, db, stmt, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	snippets, more := pageResults(snippets, f)
+	return snippets, more, nil
+}
+
+// The SearchSnippets() method uses PostgreSQL's full-text search, which is
+// backed by the GIN index on the same to_tsvector() expression, and orders the
+// results by ts_rank().
+func (db *PostgresDatabase) SearchSnippets(query string, page int) (Snippets, bool, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+WHERE s.expires > $1 AND ` + listedSnippet + `
+AND to_tsvector('english', s.title || ' ' || s.content) @@ plainto_tsquery('english', $2)
+ORDER BY ts_rank(to_tsvector('english', s.title || ' ' || s.content), plainto_tsquery('english', $2)) DESC, s.created DESC
+LIMIT $3 OFFSET $4`
+	snippets, err := querySnippets(db.Keys, db, stmt, time.Now().UTC(), query, SearchPageSize+1, searchOffset(page))
+	if err != nil {
+		return nil, false, err
+	}
+	snippets, more := searchResults(snippets)
+	return snippets, more, nil
+}
+
+func (db *PostgresDatabase) UserSnippets(userID int) (Snippets, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+WHERE s.expires > $1 AND s.user_id = $2 ORDER BY s.created DESC`
+	return querySnippets(db.Keys, db, stmt, time.Now().UTC(), userID)
+}
+
+func (db *PostgresDatabase) InsertSnippet(s *Snippet) error {
+	return withSlug(s, func() error { return db.insertSnippet(s) }, postgresDuplicate)
+}
+
+func (db *PostgresDatabase) insertSnippet(s *Snippet) error {
+	created := time.Now().UTC()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	content, err := sealContent(db.Keys, s.Content)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	stmt := `INSERT INTO snippets (user_id, title, content, key_id, wrapped_key, created, expires, burn, visibility,
+slug, password, encrypted, language) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING id`
+	var id int
+	err = tx.QueryRow(stmt, nullInt(s.UserID), s.Title, content.content, content.keyID, content.wrappedKey, created,
+		s.Expires, s.Burn, s.Visibility, s.Slug, nullBytes(s.HashedPassword), s.Encrypted, s.Language).Scan(&id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = db.insertRevision(tx, id, s.Title, s.Content, created); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	s.ID = id
+	return nil
+}
+
+func (db *PostgresDatabase) UpdateSnippet(s *Snippet) error {
+	now := time.Now().UTC()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	var oldTitle, oldContent, oldKeyID, oldWrappedKey string
+	row := tx.QueryRow(`SELECT title, content, COALESCE(key_id, ''), COALESCE(wrapped_key, '') FROM snippets
+WHERE id = $1 FOR UPDATE`, s.ID)
+	if err = row.Scan(&oldTitle, &oldContent, &oldKeyID, &oldWrappedKey); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if oldContent, err = openContent(db.Keys, oldContent, oldKeyID, oldWrappedKey); err != nil {
+		tx.Rollback()
+		return err
+	}
+	content, err := sealContent(db.Keys, s.Content)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	stmt := `UPDATE snippets SET title = $1, content = $2, key_id = $3, wrapped_key = $4, expires = $5, visibility = $6,
+password = $7, language = $8 WHERE id = $9`
+	_, err = tx.Exec(stmt, s.Title, content.content, content.keyID, content.wrappedKey, s.Expires, s.Visibility,
+		nullBytes(s.HashedPassword), s.Language, s.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if s.Title != oldTitle || s.Content != oldContent {
+		if err = db.insertRevision(tx, s.ID, s.Title, s.Content, now); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *PostgresDatabase) DeleteSnippet(id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM snippet_revisions WHERE snippet_id = $1", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM snippets WHERE id = $1", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *PostgresDatabase) BurnSnippet(s *Snippet) (bool, error) {
+	return burnSnippet(db.DB, s, dollar)
+}
+
+func (db *PostgresDatabase) SnippetBurned(slug string) (bool, error) {
+	return snippetBurned(db.DB, slug, dollar)
+}
+
+func (db *PostgresDatabase) DeleteExpiredSnippets(before time.Time, limit int) (int, error) {
+	return deleteExpired(db.DB, before, limit, dollar)
+}
+
+func (db *PostgresDatabase) DeleteBurnedSnippets(before time.Time, limit int) (int, error) {
+	return deleteBurned(db.DB, before, limit, dollar)
+}
+
+func (db *PostgresDatabase) insertRevision(tx *sql.Tx, snippetID int, title, content string, created time.Time) error {
+	c, err := sealContent(db.Keys, content)
+	if err != nil {
+		return err
+	}
+	stmt := `INSERT INTO snippet_revisions (snippet_id, revision, title, content, key_id, wrapped_key, created)
+SELECT $1, COALESCE(MAX(revision), 0) + 1, $2, $3, $4, $5, $6 FROM snippet_revisions WHERE snippet_id = $1`
+	_, err = tx.Exec(stmt, snippetID, title, c.content, c.keyID, c.wrappedKey, created)
+	return err
+}
+
+func (db *PostgresDatabase) SnippetRevisions(snippetID int) ([]*Revision, error) {
+	stmt := `SELECT ` + revisionColumns + ` FROM snippet_revisions
+WHERE snippet_id = $1 ORDER BY revision DESC`
+	return queryRevisions(db.Keys, db, stmt, snippetID)
+}
+
+func (db *PostgresDatabase) GetRevision(snippetID, number int) (*Revision, error) {
+	stmt := `SELECT ` + revisionColumns + ` FROM snippet_revisions
+WHERE snippet_id = $1 AND revision = $2`
+	revisions, err := queryRevisions(db.Keys, db, stmt, snippetID, number)
+	if err != nil || len(revisions) == 0 {
+		return nil, err
+	}
+	return revisions[0], nil
+}
+
+func (db *PostgresDatabase) ReencryptContent(batch int) (int, error) {
+	return reencryptAll(db.DB, db.Keys, batch, dollar)
+}
+
+func (db *PostgresDatabase) InsertToken(t *Token) error {
+	t.Created = time.Now().UTC()
+	stmt := `INSERT INTO api_tokens (user_id, name, scope, token_hash, prefix, created) VALUES($1, $2, $3, $4, $5, $6)
+RETURNING id`
+	return db.QueryRow(stmt, t.UserID, t.Name, t.Scope, t.Hash, t.Prefix, t.Created).Scan(&t.ID)
+}
+
+func (db *PostgresDatabase) UserTokens(userID int) ([]*Token, error) {
+	return userTokens(db.DB, userID, dollar)
+}
+
+func (db *PostgresDatabase) DeleteToken(id, userID int) error {
+	return deleteToken(db.DB, id, userID, dollar)
+}
+
+func (db *PostgresDatabase) GetTokenByHash(hash string) (*Token, error) {
+	return tokenByHash(db.DB, hash, dollar)
+}
+
+func (db *PostgresDatabase) InsertUser(name, email, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+	stmt := `INSERT INTO users (name, email, password, created) VALUES($1, $2, $3, $4)`
+	_, err = db.Exec(stmt, name, email, string(hashedPassword), time.Now().UTC())
+	if postgresDuplicate(err) {
+		return ErrDuplicateEmail
+	}
+	return err
+}
+
+func (db *PostgresDatabase) VerifyUser(email, password string) (int, error) {
+	var id int
+	var hashedPassword []byte
+
+	row := db.QueryRow("SELECT id, password FROM users WHERE email = $1", email)
+	err := row.Scan(&id, &hashedPassword)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidCredentials
+	} else if err != nil {
+		return 0, err
+	}
+	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, ErrInvalidCredentials
+	} else if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
@@ -0,0 +1,303 @@
+package models
+
+import (
+	"database/sql"
+	"github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/bcrypt"
+	"sinistra/snippetbox/pkg/keyring"
+	"strings"
+	"time"
+)
+
+// SQLiteDatabase is an implementation of the Store interface backed by an
+// embedded SQLite database file. SQLite has no equivalent of MySQL's
+// UTC_TIMESTAMP() or DATE_ADD() functions, so instead we calculate the
+// timestamps in Go and pass them in as placeholder parameters. As with the
+// MySQL Database type, content is encrypted at rest if Keys is set.
+type SQLiteDatabase struct {
+	*sql.DB
+	Keys *keyring.Keyring
+}
+
+// Check at compile time that the SQLiteDatabase type satisfies the Store interface.
+var _ Store = &SQLiteDatabase{}
+
+func (db *SQLiteDatabase) GetSnippet(id, viewerID int) (*Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+WHERE s.expires > ? AND s.id = ? AND ` + viewableByID("?")
+	return scanSnippet(db.Keys, db.QueryRow(stmt, time.Now().UTC(), id, viewerID))
+}
+
+func (db *SQLiteDatabase) GetSnippetBySlug(slug string, viewerID int) (*Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+WHERE s.expires > ? AND s.slug = ? AND ` + viewableBySlug("?")
+	return scanSnippet(db.Keys, db.QueryRow(stmt, time.Now().UTC(), slug, viewerID))
+}
+
+func (db *SQLiteDatabase) LatestSnippets() (Snippets, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+WHERE s.expires > ? AND ` + listedSnippet + ` ORDER BY s.created DESC LIMIT 10`
+	return querySnippets(db.Keys, db, stmt, time.Now().UTC())
+}
+
+func (db *SQLiteDatabase) PageSnippets(f SnippetFilter) (Snippets, bool, error) {
+	stmt, args := pageQuery(f, []string{"s.expires > ?", listedSnippet}, []interface{}{time.Now().UTC()}, questionMark)
+	snippets, err := querySnippets(db.Keys, db, stmt, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	snippets, more := pageResults(snippets, f)
+	return snippets, more, nil
+}
+
+// The SearchSnippets() method finds snippets which contain every search term in
+// their title or content. SQLite's full-text search needs an extension which
+// isn't compiled into the driver by default, so instead we use LIKE and rank
+// the results by counting the matches, weighting title matches double.
+func (db *SQLiteDatabase) SearchSnippets(query string, page int) (Snippets, bool, error) {
+	terms := SearchTerms(query)
+	if len(terms) == 0 {
+		return Snippets{}, false, nil
+	}
+
+	var conds, scores []string
+	var condArgs, scoreArgs []interface{}
+	for _, term := range terms {
+		pattern := likePattern(term)
+		conds = append(conds, `(s.title LIKE ? ESCAPE '\' OR s.content LIKE ? ESCAPE '\')`)
+		condArgs = append(condArgs, pattern, pattern)
+		scores = append(scores, `(CASE WHEN s.title LIKE ? ESCAPE '\' THEN 2 ELSE 0 END)`,
+			`(CASE WHEN s.content LIKE ? ESCAPE '\' THEN 1 ELSE 0 END)`)
+		scoreArgs = append(scoreArgs, pattern, pattern)
+	}
+
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+WHERE s.expires > ? AND ` + listedSnippet + ` AND ` + strings.Join(conds, " AND ") + `
+ORDER BY ` + strings.Join(scores, " + ") + ` DESC, s.created DESC
+LIMIT ? OFFSET ?`
+	args := append([]interface{}{time.Now().UTC()}, condArgs...)
+	args = append(args, scoreArgs...)
+	args = append(args, SearchPageSize+1, searchOffset(page))
+	snippets, err := querySnippets(db.Keys, db, stmt, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	snippets, more := searchResults(snippets)
+	return snippets, more, nil
+}
+
+func (db *SQLiteDatabase) UserSnippets(userID int) (Snippets, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+WHERE s.expires > ? AND s.user_id = ? ORDER BY s.created DESC`
+	return querySnippets(db.Keys, db, stmt, time.Now().UTC(), userID)
+}
+
+func (db *SQLiteDatabase) InsertSnippet(s *Snippet) error {
+	return withSlug(s, func() error { return db.insertSnippet(s) }, sqliteDuplicate)
+}
+
+func (db *SQLiteDatabase) insertSnippet(s *Snippet) error {
+	created := time.Now().UTC()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	content, err := sealContent(db.Keys, s.Content)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	stmt := `INSERT INTO snippets (user_id, title, content, key_id, wrapped_key, created, expires, burn, visibility,
+slug, password, encrypted, language) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.Exec(stmt, nullInt(s.UserID), s.Title, content.content, content.keyID, content.wrappedKey,
+		created, s.Expires, s.Burn, s.Visibility, s.Slug, nullBytes(s.HashedPassword), s.Encrypted, s.Language)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = db.insertRevision(tx, int(id), s.Title, s.Content, created); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	s.ID = int(id)
+	return nil
+}
+
+func (db *SQLiteDatabase) UpdateSnippet(s *Snippet) error {
+	now := time.Now().UTC()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	var oldTitle, oldContent, oldKeyID, oldWrappedKey string
+	row := tx.QueryRow(`SELECT title, content, COALESCE(key_id, ''), COALESCE(wrapped_key, '') FROM snippets
+WHERE id = ?`, s.ID)
+	if err = row.Scan(&oldTitle, &oldContent, &oldKeyID, &oldWrappedKey); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if oldContent, err = openContent(db.Keys, oldContent, oldKeyID, oldWrappedKey); err != nil {
+		tx.Rollback()
+		return err
+	}
+	content, err := sealContent(db.Keys, s.Content)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	stmt := `UPDATE snippets SET title = ?, content = ?, key_id = ?, wrapped_key = ?, expires = ?, visibility = ?,
+password = ?, language = ? WHERE id = ?`
+	_, err = tx.Exec(stmt, s.Title, content.content, content.keyID, content.wrappedKey, s.Expires, s.Visibility,
+		nullBytes(s.HashedPassword), s.Language, s.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if s.Title != oldTitle || s.Content != oldContent {
+		if err = db.insertRevision(tx, s.ID, s.Title, s.Content, now); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *SQLiteDatabase) DeleteSnippet(id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM snippet_revisions WHERE snippet_id = ?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM snippets WHERE id = ?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *SQLiteDatabase) BurnSnippet(s *Snippet) (bool, error) {
+	return burnSnippet(db.DB, s, questionMark)
+}
+
+func (db *SQLiteDatabase) SnippetBurned(slug string) (bool, error) {
+	return snippetBurned(db.DB, slug, questionMark)
+}
+
+func (db *SQLiteDatabase) DeleteExpiredSnippets(before time.Time, limit int) (int, error) {
+	return deleteExpired(db.DB, before, limit, questionMark)
+}
+
+func (db *SQLiteDatabase) DeleteBurnedSnippets(before time.Time, limit int) (int, error) {
+	return deleteBurned(db.DB, before, limit, questionMark)
+}
+
+func (db *SQLiteDatabase) insertRevision(tx *sql.Tx, snippetID int, title, content string, created time.Time) error {
+	c, err := sealContent(db.Keys, content)
+	if err != nil {
+		return err
+	}
+	stmt := `INSERT INTO snippet_revisions (snippet_id, revision, title, content, key_id, wrapped_key, created)
+SELECT ?, COALESCE(MAX(revision), 0) + 1, ?, ?, ?, ?, ? FROM snippet_revisions WHERE snippet_id = ?`
+	_, err = tx.Exec(stmt, snippetID, title, c.content, c.keyID, c.wrappedKey, created, snippetID)
+	return err
+}
+
+func (db *SQLiteDatabase) SnippetRevisions(snippetID int) ([]*Revision, error) {
+	stmt := `SELECT ` + revisionColumns + ` FROM snippet_revisions
+WHERE snippet_id = ? ORDER BY revision DESC`
+	return queryRevisions(db.Keys, db, stmt, snippetID)
+}
+
+func (db *SQLiteDatabase) GetRevision(snippetID, number int) (*Revision, error) {
+	stmt := `SELECT ` + revisionColumns + ` FROM snippet_revisions
+WHERE snippet_id = ? AND revision = ?`
+	revisions, err := queryRevisions(db.Keys, db, stmt, snippetID, number)
+	if err != nil || len(revisions) == 0 {
+		return nil, err
+	}
+	return revisions[0], nil
+}
+
+func (db *SQLiteDatabase) ReencryptContent(batch int) (int, error) {
+	return reencryptAll(db.DB, db.Keys, batch, questionMark)
+}
+
+func (db *SQLiteDatabase) InsertToken(t *Token) error {
+	t.Created = time.Now().UTC()
+	stmt := `INSERT INTO api_tokens (user_id, name, scope, token_hash, prefix, created) VALUES(?, ?, ?, ?, ?, ?)`
+	result, err := db.Exec(stmt, t.UserID, t.Name, t.Scope, t.Hash, t.Prefix, t.Created)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	t.ID = int(id)
+	return nil
+}
+
+func (db *SQLiteDatabase) UserTokens(userID int) ([]*Token, error) {
+	return userTokens(db.DB, userID, questionMark)
+}
+
+func (db *SQLiteDatabase) DeleteToken(id, userID int) error {
+	return deleteToken(db.DB, id, userID, questionMark)
+}
+
+func (db *SQLiteDatabase) GetTokenByHash(hash string) (*Token, error) {
+	return tokenByHash(db.DB, hash, questionMark)
+}
+
+func (db *SQLiteDatabase) InsertUser(name, email, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+	stmt := `INSERT INTO users (name, email, password, created) VALUES(?, ?, ?, ?)`
+	_, err = db.Exec(stmt, name, email, string(hashedPassword), time.Now().UTC())
+	if sqliteDuplicate(err) {
+		return ErrDuplicateEmail
+	}
+	return err
+}
+
+func (db *SQLiteDatabase) VerifyUser(email, password string) (int, error) {
+	var id int
+	var hashedPassword []byte
+
+	row := db.QueryRow("SELECT id, password FROM users WHERE email = ?", email)
+	err := row.Scan(&id, &hashedPassword)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidCredentials
+	} else if err != nil {
+		return 0, err
+	}
+	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, ErrInvalidCredentials
+	} else if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// SQLite reports a violation of a UNIQUE constraint with the extended error
+// code SQLITE_CONSTRAINT_UNIQUE.
+func sqliteDuplicate(err error) bool {
+	sqliteErr, ok := err.(sqlite3.Error)
+	return ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
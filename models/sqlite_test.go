@@ -0,0 +1,240 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	migrations "sinistra/snippetbox/db_migrations"
+	"sinistra/snippetbox/pkg/keyring"
+	"sinistra/snippetbox/pkg/migrate"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The newSQLiteDatabase() helper returns an SQLiteDatabase backed by a new
+// in-memory database, with the embedded migrations applied.
+func newSQLiteDatabase(t *testing.T) *SQLiteDatabase {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each connection to ":memory:" gets its own database.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	fsys, err := migrations.ForDriver("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := migrate.New(db, "sqlite3", fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Up(); err != nil {
+		t.Fatalf("applying migrations: %s", err)
+	}
+	return &SQLiteDatabase{DB: db}
+}
+
+// The keyLine() helper returns a keyring line for a new random key.
+func keyLine(t *testing.T, id string) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return id + " " + base64.StdEncoding.EncodeToString(key) + "\n"
+}
+
+// The parseKeyring() helper parses keyring lines, the first being the current
+// key.
+func parseKeyring(t *testing.T, lines ...string) *keyring.Keyring {
+	t.Helper()
+	k, err := keyring.Parse(strings.NewReader(strings.Join(lines, "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// The keyIDs() helper counts the rows of a table by the ID of the key their
+// content is sealed with, using "" for plaintext rows.
+func keyIDs(t *testing.T, db *sql.DB, table string) map[string]int {
+	t.Helper()
+	rows, err := db.Query(`SELECT COALESCE(key_id, '') FROM ` + table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	counts := map[string]int{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		counts[id]++
+	}
+	return counts
+}
+
+func TestSQLiteDatabaseReencryptContent(t *testing.T) {
+	db := newSQLiteDatabase(t)
+	var _ Reencrypter = db
+
+	if _, err := db.ReencryptContent(10); err != ErrNoKeyring {
+		t.Fatalf("ReencryptContent() without a keyring returned %v; want ErrNoKeyring", err)
+	}
+
+	// Store a snippet, with a second revision, before encryption is turned on.
+	s := &Snippet{Title: "Plain", Content: "stored before encryption", Expires: time.Now().UTC().Add(time.Hour), Visibility: Public}
+	if err := db.InsertSnippet(s); err != nil {
+		t.Fatal(err)
+	}
+	s.Content = "edited before encryption"
+	if err := db.UpdateSnippet(s); err != nil {
+		t.Fatal(err)
+	}
+	plainSnippets := keyIDs(t, db.DB, "snippets")[""]
+	plainRevisions := keyIDs(t, db.DB, "snippet_revisions")[""]
+	keyA, keyB := keyLine(t, "a"), keyLine(t, "b")
+
+	// Turn on encryption. A small batch checks that every batch is processed.
+	db.Keys = parseKeyring(t, keyA)
+	n, err := db.ReencryptContent(2)
+	if err != nil {
+		t.Fatalf("ReencryptContent() returned %s", err)
+	}
+	if n != plainSnippets+plainRevisions {
+		t.Errorf("ReencryptContent() changed %d rows; want %d", n, plainSnippets+plainRevisions)
+	}
+	if n, _ = db.ReencryptContent(2); n != 0 {
+		t.Errorf("ReencryptContent() changed %d rows the second time; want 0", n)
+	}
+	for _, table := range []string{"snippets", "snippet_revisions"} {
+		if counts := keyIDs(t, db.DB, table); counts[""] != 0 || counts["a"] == 0 {
+			t.Errorf("%s rows by key: %v; want them all sealed with a", table, counts)
+		}
+	}
+	var raw string
+	db.QueryRow(`SELECT content FROM snippets WHERE id = ?`, s.ID).Scan(&raw)
+	if strings.Contains(raw, "encryption") {
+		t.Error("the snippet content is still stored as plaintext")
+	}
+
+	// Rotate to a new key, then store a snippet sealed with it. Only the rows
+	// sealed with the old key need to be re-encrypted.
+	sealedWithA := keyIDs(t, db.DB, "snippets")["a"] + keyIDs(t, db.DB, "snippet_revisions")["a"]
+	db.Keys = parseKeyring(t, keyB, keyA)
+	s2 := &Snippet{Title: "New", Content: "stored after rotation", Expires: time.Now().UTC().Add(time.Hour), Visibility: Public}
+	if err := db.InsertSnippet(s2); err != nil {
+		t.Fatal(err)
+	}
+	n, err = db.ReencryptContent(100)
+	if err != nil {
+		t.Fatalf("ReencryptContent() after rotation returned %s", err)
+	}
+	if n != sealedWithA {
+		t.Errorf("ReencryptContent() after rotation changed %d rows; want %d", n, sealedWithA)
+	}
+
+	// Now the old key can be removed from the keyring.
+	db.Keys = parseKeyring(t, keyB)
+	for _, tt := range []struct {
+		snippet *Snippet
+		content string
+	}{
+		{s, "edited before encryption"},
+		{s2, "stored after rotation"},
+	} {
+		got, err := db.GetSnippet(tt.snippet.ID, 0)
+		if err != nil || got == nil || got.Content != tt.content {
+			t.Errorf("GetSnippet(%d) = %v, %v; want content %q", tt.snippet.ID, got, err, tt.content)
+		}
+	}
+	revision, err := db.GetRevision(s.ID, 1)
+	if err != nil || revision == nil || revision.Content != "stored before encryption" {
+		t.Errorf("GetRevision() = %v, %v; want the first revision", revision, err)
+	}
+}
+
+func TestSQLiteDatabaseUsers(t *testing.T) {
+	db := newSQLiteDatabase(t)
+	if err := db.InsertUser("Alice", "alice@example.com", "password123"); err != nil {
+		t.Fatalf("InsertUser() returned %s", err)
+	}
+	if err := db.InsertUser("Alice", "alice@example.com", "password456"); err != ErrDuplicateEmail {
+		t.Errorf("InsertUser() with a taken email returned %v; want ErrDuplicateEmail", err)
+	}
+
+	id, err := db.VerifyUser("alice@example.com", "password123")
+	if err != nil || id < 1 {
+		t.Errorf("VerifyUser() = %d, %v; want the user's ID", id, err)
+	}
+	for _, login := range [][2]string{{"alice@example.com", "password456"}, {"bob@example.com", "password123"}} {
+		if _, err := db.VerifyUser(login[0], login[1]); err != ErrInvalidCredentials {
+			t.Errorf("VerifyUser(%q, %q) returned %v; want ErrInvalidCredentials", login[0], login[1], err)
+		}
+	}
+}
+
+func TestSQLiteDatabaseDeleteBurnedSnippets(t *testing.T) {
+	db := newSQLiteDatabase(t)
+
+	// Burn three snippets, backdating the first two tombstones by a day.
+	var slugs []string
+	for i := 0; i < 3; i++ {
+		s := &Snippet{Title: "Secret", Content: "Read me once", Expires: time.Now().UTC().Add(time.Hour), Burn: true, Visibility: Unlisted}
+		if err := db.InsertSnippet(s); err != nil {
+			t.Fatal(err)
+		}
+		if burned, err := db.BurnSnippet(s); err != nil || !burned {
+			t.Fatalf("BurnSnippet() = %t, %v; want true, nil", burned, err)
+		}
+		if i < 2 {
+			if _, err := db.Exec(`UPDATE burned_snippets SET burned = ? WHERE snippet_id = ?`, time.Now().UTC().Add(-24*time.Hour), s.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		slugs = append(slugs, s.Slug)
+	}
+
+	// A batch of one leaves the other old tombstone for the next batch.
+	before := time.Now().UTC().Add(-time.Hour)
+	for _, want := range []int{1, 1, 0} {
+		if n, err := db.DeleteBurnedSnippets(before, 1); n != want || err != nil {
+			t.Fatalf("DeleteBurnedSnippets() = %d, %v; want %d, nil", n, err, want)
+		}
+	}
+	for i, slug := range slugs {
+		burned, err := db.SnippetBurned(slug)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := i == 2; burned != want {
+			t.Errorf("SnippetBurned() of snippet %d = %t; want %t", i+1, burned, want)
+		}
+	}
+}
+
+func TestSQLiteDatabasePageSnippetsTies(t *testing.T) {
+	db := newSQLiteDatabase(t)
+	var ids []int
+	for i := 0; i < 5; i++ {
+		s := &Snippet{Title: "Title", Content: "Content", Expires: time.Now().UTC().Add(time.Hour), Visibility: Public}
+		if err := db.InsertSnippet(s); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, s.ID)
+	}
+	// Give the snippets the same creation time, and expire the seed data so
+	// that it isn't listed.
+	if _, err := db.Exec(`UPDATE snippets SET created = ? WHERE id >= ?`, time.Now().UTC().Add(-time.Minute), ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`UPDATE snippets SET expires = ? WHERE id < ?`, time.Now().UTC().Add(-time.Minute), ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	testPageTies(t, ids, db.PageSnippets)
+}
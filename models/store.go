@@ -0,0 +1,97 @@
+package models
+
+import (
+	"time"
+)
+
+// The SnippetStore interface describes the snippet methods that our handlers
+// rely on. Any type which implements these methods (our MySQL-backed Database
+// type, or the in-memory MemoryStore used in tests) can be used by the web
+// application.
+//
+// GetSnippet() and GetSnippetBySlug() return nil if the snippet doesn't exist
+// or the viewer (the ID of the current user, or 0) isn't allowed to see it.
+// Only public snippets and the viewer's own snippets can be fetched by ID, which
+// is just for redirecting old URLs to the slug.
+//
+// PageSnippets() returns a page of unexpired snippets, newest first, along with
+// whether there are more snippets beyond the page in the direction of travel.
+//
+// SearchSnippets() returns a page of unexpired snippets whose title or content
+// contains every term in the query, most relevant first, along with whether
+// there's another page of results. When content is encrypted at rest the
+// database only sees the ciphertext, so only the titles of those snippets can
+// match.
+//
+// DeleteExpiredSnippets() deletes up to limit snippets which expired before the
+// given time, and returns the number deleted.
+//
+// BurnSnippet() atomically deletes a burn after reading snippet, returning
+// false if it had already been burned, and SnippetBurned() reports whether the
+// snippet with a slug was deleted that way. DeleteBurnedSnippets() deletes up
+// to limit of the tombstones left by snippets burned before the given time,
+// after which SnippetBurned() no longer reports them, and returns the number
+// deleted.
+//
+// InsertSnippet() stores a new snippet, setting its ID and a new random Slug.
+// UpdateSnippet() saves a snippet's title, content, expiry time, visibility,
+// password and language, but a snippet can't be changed to or from being
+// Encrypted. The Expires time should be Never for snippets which don't
+// expire. Both methods also record a new Revision whenever the title or
+// content changes, and DeleteSnippet() removes a snippet's revisions along
+// with it.
+type SnippetStore interface {
+	GetSnippet(id, viewerID int) (*Snippet, error)
+	GetSnippetBySlug(slug string, viewerID int) (*Snippet, error)
+	LatestSnippets() (Snippets, error)
+	PageSnippets(f SnippetFilter) (Snippets, bool, error)
+	SearchSnippets(query string, page int) (Snippets, bool, error)
+	UserSnippets(userID int) (Snippets, error)
+	InsertSnippet(s *Snippet) error
+	UpdateSnippet(s *Snippet) error
+	DeleteSnippet(id int) error
+	DeleteExpiredSnippets(before time.Time, limit int) (int, error)
+	BurnSnippet(s *Snippet) (bool, error)
+	SnippetBurned(slug string) (bool, error)
+	DeleteBurnedSnippets(before time.Time, limit int) (int, error)
+	SnippetRevisions(snippetID int) ([]*Revision, error)
+	GetRevision(snippetID, number int) (*Revision, error)
+}
+
+// The UserStore interface describes the user account methods that our
+// handlers rely on.
+type UserStore interface {
+	InsertUser(name, email, password string) error
+	VerifyUser(email, password string) (int, error)
+}
+
+// The TokenStore interface describes the personal API token methods. Tokens
+// are only ever looked up by their hash. GetTokenByHash() returns nil if there's
+// no such token, and records that the token was used. DeleteToken() only
+// deletes the token if it belongs to the user.
+type TokenStore interface {
+	InsertToken(t *Token) error
+	UserTokens(userID int) ([]*Token, error)
+	DeleteToken(id, userID int) error
+	GetTokenByHash(hash string) (*Token, error)
+}
+
+// Store combines the SnippetStore, UserStore and TokenStore interfaces. This is the type
+// that the App struct holds, so that handlers never depend on a concrete
+// storage backend.
+type Store interface {
+	SnippetStore
+	UserStore
+	TokenStore
+}
+
+// The Reencrypter interface is implemented by the SQL stores, which can encrypt
+// snippet content at rest. ReencryptContent() seals every snippet and revision
+// with the current master key, and returns the number of rows changed.
+type Reencrypter interface {
+	ReencryptContent(batch int) (int, error)
+}
+
+// Check at compile time that the Database type satisfies the Store interface.
+var _ Store = &Database{}
+var _ Reencrypter = &Database{}
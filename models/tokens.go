@@ -0,0 +1,137 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Token is a personal API token, which lets a user's scripts and tools use the
+// JSON API without a session cookie. Only the SHA-256 Hash of the token is
+// stored, so the token itself is shown to the user once, when it's created,
+// and never again. Prefix is the start of the token, so that the user can
+// tell their tokens apart. LastUsed is the zero time if the token has never
+// been used.
+type Token struct {
+	ID       int
+	UserID   int
+	Name     string
+	Scope    string
+	Hash     string
+	Prefix   string
+	Created  time.Time
+	LastUsed time.Time
+}
+
+// The token scopes. Read tokens can only fetch snippets, while write tokens
+// can also create, change and delete them.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// tokenPrefix starts every token, which makes them easy to recognise, for
+// example when scanning code for leaked credentials.
+const tokenPrefix = "sbx_"
+
+// Allows reports whether the token may be used for requests needing the scope.
+func (t *Token) Allows(scope string) bool {
+	return t.Scope == ScopeWrite || t.Scope == scope
+}
+
+// NewToken returns a new random token, made of the tokenPrefix and 32 bytes
+// from crypto/rand, along with a Token holding its hash and prefix.
+func NewToken(userID int, name, scope string) (string, *Token, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	token := tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, &Token{
+		UserID: userID,
+		Name:   name,
+		Scope:  scope,
+		Hash:   HashToken(token),
+		Prefix: token[:len(tokenPrefix)+6],
+	}, nil
+}
+
+// HashToken returns the hex-encoded SHA-256 digest of a token. Tokens are
+// long and random, so unlike passwords they don't need a slow hash like
+// bcrypt, and the digest can be looked up directly.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// The SQL backends share the statements for tokens, apart from inserting them.
+const tokenColumns = `id, user_id, name, scope, token_hash, prefix, created, last_used`
+
+// The scanToken() helper copies the tokenColumns from a row into a new Token.
+// If the row doesn't exist it returns nil.
+func scanToken(row scanner) (*Token, error) {
+	t := &Token{}
+	var lastUsed sql.NullTime
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Scope, &t.Hash, &t.Prefix, &t.Created, &lastUsed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	t.LastUsed = lastUsed.Time
+	return t, nil
+}
+
+func userTokens(db *sql.DB, userID int, placeholder func(n int) string) ([]*Token, error) {
+	stmt := `SELECT ` + tokenColumns + ` FROM api_tokens WHERE user_id = ` + placeholder(1) + ` ORDER BY created DESC, id DESC`
+	rows, err := db.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*Token{}
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// The deleteToken() helper revokes a token. The user ID is part of the
+// condition so that users can only revoke their own tokens.
+func deleteToken(db *sql.DB, id, userID int, placeholder func(n int) string) error {
+	stmt := fmt.Sprintf("DELETE FROM api_tokens WHERE id = %s AND user_id = %s", placeholder(1), placeholder(2))
+	_, err := db.Exec(stmt, id, userID)
+	return err
+}
+
+// The tokenByHash() helper finds the token with the given hash, and records
+// that it has been used. The last used time is only updated once a minute, so
+// that a busy script doesn't cause a write for every request.
+func tokenByHash(db *sql.DB, hash string, placeholder func(n int) string) (*Token, error) {
+	stmt := `SELECT ` + tokenColumns + ` FROM api_tokens WHERE token_hash = ` + placeholder(1)
+	t, err := scanToken(db.QueryRow(stmt, hash))
+	if err != nil || t == nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if now.Sub(t.LastUsed) >= time.Minute {
+		stmt = fmt.Sprintf("UPDATE api_tokens SET last_used = %s WHERE id = %s", placeholder(1), placeholder(2))
+		if _, err = db.Exec(stmt, now, t.ID); err != nil {
+			return nil, err
+		}
+		t.LastUsed = now
+	}
+	return t, nil
+}
@@ -0,0 +1,240 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MaxLines is the largest number of lines in either text that Lines will
+// compare, and MaxEdits is the largest number of inserted and deleted lines it
+// will search for. Together they bound the time and memory used by a diff.
+const (
+	MaxLines = 20000
+	MaxEdits = 2000
+)
+
+// ErrTooLarge is returned when the texts are longer than MaxLines, or differ
+// by more than MaxEdits lines.
+var ErrTooLarge = errors.New("diff: texts are too large or too different to compare")
+
+// Kind describes whether a line is common to both texts, or was deleted from
+// the old text or inserted into the new text.
+type Kind int
+
+const (
+	Equal Kind = iota
+	Delete
+	Insert
+)
+
+// Line is a single line of a diff. OldNum and NewNum are the 1-based line
+// numbers in the old and new texts, and are zero when the line doesn't appear
+// in that text.
+type Line struct {
+	Kind   Kind
+	Text   string
+	OldNum int
+	NewNum int
+}
+
+// Prefix returns the character used for the line in unified diff output.
+func (l Line) Prefix() string {
+	switch l.Kind {
+	case Delete:
+		return "-"
+	case Insert:
+		return "+"
+	}
+	return " "
+}
+
+// Class returns a CSS class name for the line.
+func (l Line) Class() string {
+	switch l.Kind {
+	case Delete:
+		return "delete"
+	case Insert:
+		return "insert"
+	}
+	return "equal"
+}
+
+// Hunk is a group of changed lines, surrounded by some unchanged context.
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []Line
+}
+
+// Header returns the "@@ -l,s +l,s @@" range header for the hunk.
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+}
+
+// Unified returns the hunks of a unified line diff between the old and new
+// texts, with the given number of lines of context around each change. It
+// returns nil if the texts are the same, and ErrTooLarge if they can't be
+// compared.
+func Unified(old, new string, context int) ([]Hunk, error) {
+	lines, err := Lines(old, new)
+	if err != nil {
+		return nil, err
+	}
+
+	var hunks []Hunk
+	for i := 0; i < len(lines); {
+		if lines[i].Kind == Equal {
+			i++
+			continue
+		}
+		// Start a hunk with up to context lines before the first change, then
+		// keep extending it while the next change is within 2*context lines.
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(lines) {
+			if lines[end].Kind != Equal {
+				end++
+				continue
+			}
+			next := end
+			for next < len(lines) && lines[next].Kind == Equal {
+				next++
+			}
+			if next == len(lines) || next-end > 2*context {
+				end += context
+				if end > len(lines) {
+					end = len(lines)
+				}
+				break
+			}
+			end = next
+		}
+		hunks = append(hunks, newHunk(lines[start:end]))
+		i = end
+	}
+	return hunks, nil
+}
+
+// The newHunk() function calculates the line ranges covered by a hunk.
+func newHunk(lines []Line) Hunk {
+	h := Hunk{Lines: lines}
+	for _, l := range lines {
+		if l.Kind != Insert {
+			if h.OldStart == 0 {
+				h.OldStart = l.OldNum
+			}
+			h.OldLines++
+		}
+		if l.Kind != Delete {
+			if h.NewStart == 0 {
+				h.NewStart = l.NewNum
+			}
+			h.NewLines++
+		}
+	}
+	return h
+}
+
+// Lines returns the full line by line diff between the old and new texts,
+// using the Myers algorithm to find the shortest edit script. It returns
+// ErrTooLarge if either text has more than MaxLines lines, or if the texts
+// differ by more than MaxEdits lines.
+func Lines(old, new string) ([]Line, error) {
+	a, b := split(old), split(new)
+	n, m := len(a), len(b)
+	if n > MaxLines || m > MaxLines {
+		return nil, ErrTooLarge
+	}
+	max := n + m
+	if max > MaxEdits {
+		max = MaxEdits
+	}
+	offset := max + 1
+
+	// v holds the furthest reaching x value for each diagonal k. Before each
+	// step d, only the diagonals -d to d can have been reached, so trace holds
+	// a copy of just those 2d+1 values for each step, to backtrack through.
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b), nil
+			}
+		}
+	}
+	return nil, ErrTooLarge
+}
+
+// The backtrack() function walks the trace from the end of both texts back to
+// the start, and returns the edit script in order. The values for diagonal k
+// at step d are at trace[d][d+k].
+func backtrack(trace [][]int, a, b []string) []Line {
+	var reversed []Line
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[d+k-1] < v[d+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		var prevX int
+		if d > 0 {
+			prevX = v[d+prevK]
+		}
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversed = append(reversed, Line{Kind: Equal, Text: a[x-1], OldNum: x, NewNum: y})
+			x--
+			y--
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			reversed = append(reversed, Line{Kind: Insert, Text: b[y-1], NewNum: y})
+		} else {
+			reversed = append(reversed, Line{Kind: Delete, Text: a[x-1], OldNum: x})
+		}
+		x, y = prevX, prevY
+	}
+
+	lines := make([]Line, len(reversed))
+	for i, l := range reversed {
+		lines[len(reversed)-1-i] = l
+	}
+	return lines
+}
+
+// The split() function splits text into lines. Browsers submit textarea
+// contents with CRLF line endings, so these are normalized first.
+func split(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
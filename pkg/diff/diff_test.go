@@ -0,0 +1,164 @@
+package diff
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want string
+	}{
+		{"empty", "", "", ""},
+		{"identical", "a\nb\nc\n", "a\nb\nc\n", " a| b| c"},
+		{"crlf", "a\r\nb\r\n", "a\nb\n", " a| b"},
+		{"insert", "a\nc\n", "a\nb\nc\n", " a|+b| c"},
+		{"delete", "a\nb\nc\n", "a\nc\n", " a|-b| c"},
+		{"replace", "a\nb\nc\n", "a\nx\nc\n", " a|-b|+x| c"},
+		{"from empty", "", "a\nb\n", "+a|+b"},
+		{"to empty", "a\nb\n", "", "-a|-b"},
+		{"all different", "a\nb\n", "x\ny\n", "-a|-b|+x|+y"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := Lines(tt.old, tt.new)
+			if err != nil {
+				t.Fatalf("Lines() returned %s", err)
+			}
+			var got []string
+			for _, l := range lines {
+				got = append(got, l.Prefix()+l.Text)
+			}
+			if s := strings.Join(got, "|"); s != tt.want {
+				t.Errorf("Lines() = %q; want %q", s, tt.want)
+			}
+		})
+	}
+}
+
+// The TestLinesReproduceTexts test checks that the old and new texts can be
+// rebuilt from the diff of a range of edits, with the right line numbers.
+func TestLinesReproduceTexts(t *testing.T) {
+	var old []string
+	for i := 0; i < 200; i++ {
+		old = append(old, fmt.Sprint("line ", i))
+	}
+	for step := 1; step < 20; step++ {
+		var new []string
+		for i, l := range old {
+			switch {
+			case i%step == 0:
+				new = append(new, "changed "+l)
+			case i%(step+3) == 0:
+				// Deleted.
+			default:
+				new = append(new, l)
+			}
+		}
+		oldText, newText := strings.Join(old, "\n"), strings.Join(new, "\n")
+
+		lines, err := Lines(oldText, newText)
+		if err != nil {
+			t.Fatalf("step %d: Lines() returned %s", step, err)
+		}
+		var gotOld, gotNew []string
+		for _, l := range lines {
+			if l.Kind != Insert {
+				gotOld = append(gotOld, l.Text)
+				if l.OldNum != len(gotOld) {
+					t.Fatalf("step %d: line %q has OldNum %d; want %d", step, l.Text, l.OldNum, len(gotOld))
+				}
+			}
+			if l.Kind != Delete {
+				gotNew = append(gotNew, l.Text)
+				if l.NewNum != len(gotNew) {
+					t.Fatalf("step %d: line %q has NewNum %d; want %d", step, l.Text, l.NewNum, len(gotNew))
+				}
+			}
+		}
+		if strings.Join(gotOld, "\n") != oldText {
+			t.Errorf("step %d: diff doesn't reproduce the old text", step)
+		}
+		if strings.Join(gotNew, "\n") != newText {
+			t.Errorf("step %d: diff doesn't reproduce the new text", step)
+		}
+	}
+}
+
+func TestUnified(t *testing.T) {
+	var old []string
+	for i := 1; i <= 20; i++ {
+		old = append(old, fmt.Sprint(i))
+	}
+	new := append([]string(nil), old...)
+	new[1] = "two"
+	new[17] = "eighteen"
+
+	hunks, err := Unified(strings.Join(old, "\n"), strings.Join(new, "\n"), 3)
+	if err != nil {
+		t.Fatalf("Unified() returned %s", err)
+	}
+	want := []string{"@@ -1,5 +1,5 @@", "@@ -15,6 +15,6 @@"}
+	if len(hunks) != len(want) {
+		t.Fatalf("Unified() returned %d hunks; want %d", len(hunks), len(want))
+	}
+	for i, h := range hunks {
+		if h.Header() != want[i] {
+			t.Errorf("hunk %d has header %q; want %q", i, h.Header(), want[i])
+		}
+	}
+
+	hunks, err = Unified("a\nb\n", "a\nb\n", 3)
+	if err != nil || hunks != nil {
+		t.Errorf("Unified() of identical texts = %v, %v; want nil, nil", hunks, err)
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	many := strings.Repeat("x\n", MaxLines+1)
+	if _, err := Lines(many, "x\n"); err != ErrTooLarge {
+		t.Errorf("Lines() of %d lines returned %v; want ErrTooLarge", MaxLines+1, err)
+	}
+
+	var old, new []string
+	for i := 0; i < MaxEdits; i++ {
+		old = append(old, fmt.Sprint("old ", i))
+		new = append(new, fmt.Sprint("new ", i))
+	}
+	if _, err := Unified(strings.Join(old, "\n"), strings.Join(new, "\n"), 3); err != ErrTooLarge {
+		t.Errorf("Unified() of texts with %d edits returned %v; want ErrTooLarge", 2*MaxEdits, err)
+	}
+}
+
+// The TestMemoryBounded test checks that the trace only grows with the edit
+// distance, and not with the length of the texts. Copying every diagonal on
+// each step would allocate over 600 MB for these texts.
+func TestMemoryBounded(t *testing.T) {
+	var old, new []string
+	for i := 0; i < MaxLines; i++ {
+		old = append(old, fmt.Sprint("line ", i))
+		if i%20 == 0 {
+			new = append(new, fmt.Sprint("changed ", i))
+		} else {
+			new = append(new, fmt.Sprint("line ", i))
+		}
+	}
+	oldText, newText := strings.Join(old, "\n"), strings.Join(new, "\n")
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := Lines(oldText, newText)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatalf("Lines() returned %s", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+		t.Errorf("Lines() allocated %d MB; want at most 64 MB", allocated>>20)
+	}
+}
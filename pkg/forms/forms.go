@@ -1,25 +1,54 @@
 package forms
 
 import (
+	"encoding/base64"
+	"fmt"
 	"regexp"
+	"sinistra/snippetbox/pkg/syntax"
+	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 )
 
 var rxEmail = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0]))")
 
 // Declare a struct to hold the form values (and also a map to hold any validation failure messages).
+// Expires holds the name of one of the Policy's presets, or "custom" in which
+// case ExpiresAt holds a date and time from a datetime-local input. After a
+// successful call to Valid(), ExpiresTime holds the resulting expiry time, or
+// the zero time if the snippet never expires. Visibility is "public",
+// "unlisted" or "private". Password is optional; when editing a snippet a
+// blank Password keeps the current one, and RemovePassword removes it. If
+// Encrypted is set, Content holds the base64-encoded IV and ciphertext
+// produced by the browser rather than the snippet's text. Language is one of
+// the syntax.Languages, or empty to detect the language automatically. If
+// KeepExpires is set the snippet's current expiry time is being kept, so
+// Expires isn't checked.
 type NewSnippet struct {
-	Title    string
-	Content  string
-	Expires  string
-	Failures map[string]string
+	Title          string
+	Content        string
+	Expires        string
+	ExpiresAt      string
+	ExpiresTime    time.Time
+	KeepExpires    bool
+	Burn           bool
+	Visibility     string
+	Password       string
+	RemovePassword bool
+	Encrypted      bool
+	Language       string
+	Policy         *ExpiryPolicy
+	Failures       map[string]string
 }
 
 // Implement an Valid() method which carries out validation checks on the form
 // fields and returns true if there are no failures.
 func (f *NewSnippet) Valid() bool {
 	f.Failures = make(map[string]string)
+	if f.Policy == nil {
+		f.Policy = DefaultExpiryPolicy
+	}
 	// Check that the Title field is not blank and is not more than 100 characters long.
 	// If it fails either of those checks, add a message to the f.Failures
 	// map using the field name as the key.
@@ -31,25 +60,60 @@ func (f *NewSnippet) Valid() bool {
 	// Validate the Content and Expires fields aren't blank in a similar way.
 	if strings.TrimSpace(f.Content) == "" {
 		f.Failures["Content"] = "Content is required"
+	} else if f.Encrypted && !validCiphertext(f.Content) {
+		f.Failures["Content"] = "Encrypted content is malformed"
 	}
-	// Check that the Expires field isn't blank and is one of a fixed list.
-	// Using a lookup on a map keyed with the permitted options and values of true is a
-	// neat trick which saves you looping over the permitted values.
-	permitted := map[string]bool{
-		"3600":     true,
-		"86400":    true,
-		"31536000": true,
+	// Unless the current expiry time is being kept, check that the Expires
+	// field isn't blank, and is either one of the presets permitted by the
+	// policy or a custom date and time.
+	now := time.Now().UTC()
+	if !f.KeepExpires {
+		if strings.TrimSpace(f.Expires) == "" {
+			f.Failures["Expires"] = "Expiry time is required"
+		} else if f.Expires == "custom" && f.Policy.AllowCustom {
+			t, err := time.Parse(DateTimeLayout, f.ExpiresAt)
+			switch {
+			case err != nil:
+				f.Failures["Expires"] = "Expiry date must be a date and time in the format yyyy-mm-ddThh:mm"
+			case !t.After(now):
+				f.Failures["Expires"] = "Expiry date must be in the future"
+			case f.Policy.MaxExpiry > 0 && t.Sub(now) > f.Policy.MaxExpiry:
+				f.Failures["Expires"] = "Expiry date cannot be more than " + f.Policy.MaxExpiry.String() + " from now"
+			default:
+				f.ExpiresTime = t
+			}
+		} else if preset, ok := f.Policy.Preset(f.Expires); ok {
+			if preset.Duration > 0 {
+				f.ExpiresTime = now.Add(preset.Duration)
+			}
+		} else {
+			f.Failures["Expires"] = "Expiry time must be one of the listed options"
+		}
 	}
-
-	if strings.TrimSpace(f.Expires) == "" {
-		f.Failures["Expires"] = "Expiry time is required"
-	} else if !permitted[f.Expires] {
-		f.Failures["Expires"] = "Expiry time must be 3600, 86400 or 31536000 seconds"
+	switch f.Visibility {
+	case "public", "unlisted", "private":
+	case "":
+		f.Failures["Visibility"] = "Visibility is required"
+	default:
+		f.Failures["Visibility"] = "Visibility must be public, unlisted or private"
+	}
+	if f.Password != "" && utf8.RuneCountInString(f.Password) < 8 {
+		f.Failures["Password"] = "Password cannot be shorter than 8 characters"
+	}
+	if f.Language != "" && !syntax.Supported(f.Language) {
+		f.Failures["Language"] = "Language must be one of the listed options"
 	}
 	// If there are no failure messages, return true.
 	return len(f.Failures) == 0
 }
 
+// The validCiphertext() function checks that encrypted content is base64 and
+// long enough to hold the 12 byte AES-GCM IV and 16 byte authentication tag.
+func validCiphertext(content string) bool {
+	b, err := base64.StdEncoding.DecodeString(content)
+	return err == nil && len(b) >= 12+16
+}
+
 type SignupUser struct {
 	Name     string
 	Email    string
@@ -89,3 +153,183 @@ func (f *LoginUser) Valid() bool {
 	}
 	return len(f.Failures) == 0
 }
+
+// UnlockSnippet holds the password entered to view a password-protected
+// snippet.
+type UnlockSnippet struct {
+	Password string
+	Failures map[string]string
+}
+
+func (f *UnlockSnippet) Valid() bool {
+	f.Failures = make(map[string]string)
+	if f.Password == "" {
+		f.Failures["Password"] = "Password is required"
+	}
+	return len(f.Failures) == 0
+}
+
+// NewToken holds the name and scope for a new personal access token. Scope is
+// "read" or "write".
+type NewToken struct {
+	Name     string
+	Scope    string
+	Failures map[string]string
+}
+
+func (f *NewToken) Valid() bool {
+	f.Failures = make(map[string]string)
+	if strings.TrimSpace(f.Name) == "" {
+		f.Failures["Name"] = "Name is required"
+	} else if utf8.RuneCountInString(f.Name) > 100 {
+		f.Failures["Name"] = "Name cannot be longer than 100 characters"
+	}
+	if f.Scope != "read" && f.Scope != "write" {
+		f.Failures["Scope"] = "Scope must be read or write"
+	}
+	return len(f.Failures) == 0
+}
+
+// ArchiveFilter holds the optional created-date range for the snippet archive.
+// Dates are in the yyyy-mm-dd format used by HTML date inputs.
+type ArchiveFilter struct {
+	From     string
+	To       string
+	Failures map[string]string
+}
+
+// DateLayout is the layout used to parse ArchiveFilter dates.
+const DateLayout = "2006-01-02"
+
+func (f *ArchiveFilter) Valid() bool {
+	f.Failures = make(map[string]string)
+	var from, to time.Time
+	var err error
+	if f.From != "" {
+		if from, err = time.Parse(DateLayout, f.From); err != nil {
+			f.Failures["From"] = "From must be a date in the format yyyy-mm-dd"
+		}
+	}
+	if f.To != "" {
+		if to, err = time.Parse(DateLayout, f.To); err != nil {
+			f.Failures["To"] = "To must be a date in the format yyyy-mm-dd"
+		}
+	}
+	if len(f.Failures) == 0 && !from.IsZero() && !to.IsZero() && to.Before(from) {
+		f.Failures["To"] = "To cannot be earlier than From"
+	}
+	return len(f.Failures) == 0
+}
+
+// DateTimeLayout is the layout used by datetime-local inputs. Custom expiry
+// times are interpreted as UTC.
+const DateTimeLayout = "2006-01-02T15:04"
+
+// ExpiryPreset is one of the expiry choices offered on the new snippet form.
+// A zero Duration means that the snippet never expires.
+type ExpiryPreset struct {
+	Name     string
+	Label    string
+	Duration time.Duration
+}
+
+// ExpiryPolicy holds the server-side rules for snippet expiry times: the
+// presets which are offered, the preset selected by default, whether a custom
+// date may be given, and the maximum time until expiry for custom dates (zero
+// for no limit).
+type ExpiryPolicy struct {
+	Presets     []ExpiryPreset
+	Default     string
+	AllowCustom bool
+	MaxExpiry   time.Duration
+}
+
+// DefaultExpiryPolicy offers the original one hour, one day and one year
+// choices.
+var DefaultExpiryPolicy = &ExpiryPolicy{
+	Presets: []ExpiryPreset{
+		{Name: "1y", Label: "1 year", Duration: 365 * 24 * time.Hour},
+		{Name: "1d", Label: "1 day", Duration: 24 * time.Hour},
+		{Name: "1h", Label: "1 hour", Duration: time.Hour},
+	},
+	Default: "1y",
+}
+
+// NewExpiryPolicy builds an ExpiryPolicy from a comma-separated list of preset
+// names. It's an error for a preset to exceed maxExpiry (including "never"
+// when there is a maximum), or for the default not to be one of the presets.
+func NewExpiryPolicy(presets, def string, allowCustom bool, maxExpiry time.Duration) (*ExpiryPolicy, error) {
+	p := &ExpiryPolicy{Default: def, AllowCustom: allowCustom, MaxExpiry: maxExpiry}
+	var err error
+	if p.Presets, err = ParseExpiryPresets(presets); err != nil {
+		return nil, err
+	}
+	for _, preset := range p.Presets {
+		if maxExpiry > 0 && (preset.Duration == 0 || preset.Duration > maxExpiry) {
+			return nil, fmt.Errorf("forms: expiry preset %q exceeds the maximum expiry of %s", preset.Name, maxExpiry)
+		}
+	}
+	if _, ok := p.Preset(def); !ok {
+		return nil, fmt.Errorf("forms: default expiry %q is not one of the presets", def)
+	}
+	return p, nil
+}
+
+// Preset returns the preset with the given name.
+func (p *ExpiryPolicy) Preset(name string) (ExpiryPreset, bool) {
+	for _, preset := range p.Presets {
+		if preset.Name == name {
+			return preset, true
+		}
+	}
+	return ExpiryPreset{}, false
+}
+
+var rxPreset = regexp.MustCompile(`^([0-9]+)(m|h|d|w|mo|y)$`)
+
+var presetUnits = map[string]struct {
+	name     string
+	duration time.Duration
+}{
+	"m":  {"minute", time.Minute},
+	"h":  {"hour", time.Hour},
+	"d":  {"day", 24 * time.Hour},
+	"w":  {"week", 7 * 24 * time.Hour},
+	"mo": {"month", 30 * 24 * time.Hour},
+	"y":  {"year", 365 * 24 * time.Hour},
+}
+
+// ParseExpiryPreset parses a preset name such as "10m", "1w", "1mo" or
+// "never", and returns the preset with a human readable label.
+func ParseExpiryPreset(name string) (ExpiryPreset, error) {
+	if name == "never" {
+		return ExpiryPreset{Name: name, Label: "Never"}, nil
+	}
+	m := rxPreset.FindStringSubmatch(name)
+	if m == nil {
+		return ExpiryPreset{}, fmt.Errorf("forms: invalid expiry preset %q", name)
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil || n < 1 {
+		return ExpiryPreset{}, fmt.Errorf("forms: invalid expiry preset %q", name)
+	}
+	unit := presetUnits[m[2]]
+	label := fmt.Sprintf("%d %s", n, unit.name)
+	if n > 1 {
+		label += "s"
+	}
+	return ExpiryPreset{Name: name, Label: label, Duration: time.Duration(n) * unit.duration}, nil
+}
+
+// ParseExpiryPresets parses a comma-separated list of preset names.
+func ParseExpiryPresets(list string) ([]ExpiryPreset, error) {
+	var presets []ExpiryPreset
+	for _, name := range strings.Split(list, ",") {
+		preset, err := ParseExpiryPreset(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		presets = append(presets, preset)
+	}
+	return presets, nil
+}
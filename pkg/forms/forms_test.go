@@ -0,0 +1,177 @@
+package forms
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseExpiryPreset(t *testing.T) {
+	tests := []struct {
+		name     string
+		label    string
+		duration time.Duration
+		wantErr  bool
+	}{
+		{"10m", "10 minutes", 10 * time.Minute, false},
+		{"1h", "1 hour", time.Hour, false},
+		{"1d", "1 day", 24 * time.Hour, false},
+		{"2w", "2 weeks", 14 * 24 * time.Hour, false},
+		{"1mo", "1 month", 30 * 24 * time.Hour, false},
+		{"6mo", "6 months", 180 * 24 * time.Hour, false},
+		{"1y", "1 year", 365 * 24 * time.Hour, false},
+		{"never", "Never", 0, false},
+		{"", "", 0, true},
+		{"0d", "", 0, true},
+		{"-1d", "", 0, true},
+		{"10", "", 0, true},
+		{"d", "", 0, true},
+		{"1x", "", 0, true},
+		{"1.5h", "", 0, true},
+		{"1D", "", 0, true},
+		{"Never", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preset, err := ParseExpiryPreset(tt.name)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseExpiryPreset(%q) = %+v; want an error", tt.name, preset)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseExpiryPreset(%q) returned %s", tt.name, err)
+			}
+			if preset.Name != tt.name || preset.Label != tt.label || preset.Duration != tt.duration {
+				t.Errorf("ParseExpiryPreset(%q) = %+v; want label %q and duration %s", tt.name, preset, tt.label, tt.duration)
+			}
+		})
+	}
+}
+
+func TestNewExpiryPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		presets   string
+		def       string
+		maxExpiry time.Duration
+		want      []string
+		wantErr   string
+	}{
+		{"presets in order", "10m,1h,1d,1w,1mo,never", "1d", 0, []string{"10m", "1h", "1d", "1w", "1mo", "never"}, ""},
+		{"spaces around names", " 1h , 1d ", "1h", 0, []string{"1h", "1d"}, ""},
+		{"within the maximum", "1h,1w", "1h", 7 * 24 * time.Hour, []string{"1h", "1w"}, ""},
+		{"invalid preset", "1h,soon", "1h", 0, nil, `invalid expiry preset "soon"`},
+		{"empty list", "", "1h", 0, nil, `invalid expiry preset ""`},
+		{"default not offered", "1h,1d", "1w", 0, nil, `default expiry "1w" is not one of the presets`},
+		{"preset over the maximum", "1h,1mo", "1h", 7 * 24 * time.Hour, nil, `"1mo" exceeds the maximum expiry`},
+		{"never with a maximum", "1h,never", "1h", 7 * 24 * time.Hour, nil, `"never" exceeds the maximum expiry`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewExpiryPolicy(tt.presets, tt.def, true, tt.maxExpiry)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewExpiryPolicy() returned error %v; want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewExpiryPolicy() returned %s", err)
+			}
+			var names []string
+			for _, preset := range p.Presets {
+				names = append(names, preset.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("presets = %v; want %v", names, tt.want)
+			}
+			if p.Default != tt.def || !p.AllowCustom || p.MaxExpiry != tt.maxExpiry {
+				t.Errorf("policy = %+v; want default %q, custom dates and maximum %s", p, tt.def, tt.maxExpiry)
+			}
+		})
+	}
+}
+
+func TestNewSnippetExpires(t *testing.T) {
+	policy, err := NewExpiryPolicy("10m,1d,1mo,never", "1d", true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited, err := NewExpiryPolicy("10m,1d", "1d", true, 7*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	presetsOnly, err := NewExpiryPolicy("10m,1d", "1d", false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	at := func(d time.Duration) string {
+		return now.Add(d).Format(DateTimeLayout)
+	}
+
+	tests := []struct {
+		name      string
+		policy    *ExpiryPolicy
+		expires   string
+		expiresAt string
+		// want is the expected time until expiry, or zero for never. The
+		// failure message is checked instead if wantFailure is set.
+		want        time.Duration
+		wantFailure string
+	}{
+		{"preset", policy, "10m", "", 10 * time.Minute, ""},
+		{"longer preset", policy, "1mo", "", 30 * 24 * time.Hour, ""},
+		{"never", policy, "never", "", 0, ""},
+		{"preset not offered", policy, "1y", "", 0, "must be one of the listed options"},
+		{"blank", policy, " ", "", 0, "Expiry time is required"},
+		{"nil policy uses the defaults", nil, "1y", "", 365 * 24 * time.Hour, ""},
+		{"nil policy has no never", nil, "never", "", 0, "must be one of the listed options"},
+		{"custom", policy, "custom", at(48 * time.Hour), 48 * time.Hour, ""},
+		{"custom far in the future", policy, "custom", at(10 * 365 * 24 * time.Hour), 10 * 365 * 24 * time.Hour, ""},
+		{"custom in the past", policy, "custom", at(-time.Hour), 0, "must be in the future"},
+		{"custom malformed", policy, "custom", "tomorrow", 0, "format yyyy-mm-ddThh:mm"},
+		{"custom with seconds", policy, "custom", now.Add(time.Hour).Format(time.RFC3339), 0, "format yyyy-mm-ddThh:mm"},
+		{"custom within the maximum", limited, "custom", at(6 * 24 * time.Hour), 6 * 24 * time.Hour, ""},
+		{"custom over the maximum", limited, "custom", at(8 * 24 * time.Hour), 0, "cannot be more than 168h0m0s from now"},
+		{"custom not allowed", presetsOnly, "custom", at(time.Hour), 0, "must be one of the listed options"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &NewSnippet{
+				Title:      "Title",
+				Content:    "Content",
+				Expires:    tt.expires,
+				ExpiresAt:  tt.expiresAt,
+				Visibility: "public",
+				Policy:     tt.policy,
+			}
+			valid := f.Valid()
+			if tt.wantFailure != "" {
+				if valid || !strings.Contains(f.Failures["Expires"], tt.wantFailure) {
+					t.Fatalf("Expires failure = %q; want one containing %q", f.Failures["Expires"], tt.wantFailure)
+				}
+				return
+			}
+			if !valid {
+				t.Fatalf("Valid() failed: %v", f.Failures)
+			}
+			if tt.want == 0 {
+				if !f.ExpiresTime.IsZero() {
+					t.Errorf("ExpiresTime = %s; want zero for never", f.ExpiresTime)
+				}
+				return
+			}
+			// Custom times are truncated to the minute, and a little time passes
+			// while the test runs.
+			if got := f.ExpiresTime.Sub(now); got < tt.want-time.Minute || got > tt.want+time.Minute {
+				t.Errorf("ExpiresTime is %s from now; want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package keyring
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Keyring holds the master keys used for envelope encryption. Each value is
+// encrypted with its own random data key, and the data key is encrypted
+// ("wrapped") with a master key. Only the wrapped data key and the master
+// key's ID are stored alongside the ciphertext, so rotating the master key
+// means re-wrapping the data keys rather than keeping every old key forever.
+//
+// New values are always sealed with the current key, which is the first key
+// in the keyring. The other keys are only used to open values sealed before
+// the current key was added.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// Envelope holds an encrypted value along with what's needed to decrypt it,
+// given the master key with ID KeyID.
+type Envelope struct {
+	KeyID      string
+	WrappedKey []byte
+	Ciphertext []byte
+}
+
+var (
+	ErrUnknownKey = errors.New("keyring: value was sealed with an unknown master key")
+	ErrNoKeys     = errors.New("keyring: no master keys")
+)
+
+// Parse reads a keyring from text with one master key per line, in the form
+// "<id> <base64-encoded 32 byte key>". Blank lines and lines starting with #
+// are ignored. The first key is the current key. A new key can be generated
+// with:
+//
+//	echo "2019-09 $(head -c 32 /dev/urandom | base64)"
+func Parse(r io.Reader) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("keyring: line %d: expected a key ID and a key", n)
+		}
+		id := fields[0]
+		if _, exists := k.keys[id]; exists {
+			return nil, fmt.Errorf("keyring: line %d: duplicate key ID %q", n, id)
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("keyring: line %d: key must be 32 bytes encoded as base64", n)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = aead
+		if k.current == "" {
+			k.current = id
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if k.current == "" {
+		return nil, ErrNoKeys
+	}
+	return k, nil
+}
+
+// Current returns the ID of the key used to seal new values.
+func (k *Keyring) Current() string {
+	return k.current
+}
+
+// Seal encrypts plaintext with a new random data key, and wraps the data key
+// with the current master key.
+func (k *Keyring) Seal(plaintext []byte) (*Envelope, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := seal(data, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	wrappedKey, err := seal(k.keys[k.current], dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{KeyID: k.current, WrappedKey: wrappedKey, Ciphertext: ciphertext}, nil
+}
+
+// Open unwraps the envelope's data key and decrypts the ciphertext.
+func (k *Keyring) Open(e *Envelope) ([]byte, error) {
+	master, ok := k.keys[e.KeyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	dataKey, err := open(master, e.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return open(data, e.Ciphertext)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// The seal() function encrypts plaintext with a random nonce, which is
+// prepended to the ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("keyring: ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
@@ -0,0 +1,187 @@
+package keyring
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// The newKey() helper returns a keyring line for a new random key.
+func newKey(t *testing.T, id string) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return id + " " + base64.StdEncoding.EncodeToString(key) + "\n"
+}
+
+// The mustParse() helper parses a keyring which is expected to be valid.
+func mustParse(t *testing.T, text string) *Keyring {
+	t.Helper()
+	k, err := Parse(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("Parse() returned %s", err)
+	}
+	return k
+}
+
+func TestParse(t *testing.T) {
+	key := newKey(t, "a")
+	short := "b " + base64.StdEncoding.EncodeToString(make([]byte, 16)) + "\n"
+
+	tests := []struct {
+		name    string
+		text    string
+		current string
+		wantErr string
+	}{
+		{"one key", key, "a", ""},
+		{"first key is current", newKey(t, "new") + newKey(t, "old"), "new", ""},
+		{"comments and blank lines", "# keys\n\n" + key, "a", ""},
+		{"empty", "", "", "no master keys"},
+		{"only comments", "# nothing here\n", "", "no master keys"},
+		{"missing key", "a\n", "", "line 1: expected a key ID and a key"},
+		{"not base64", "a !!!\n", "", "line 1: key must be 32 bytes"},
+		{"short key", short, "", "line 1: key must be 32 bytes"},
+		{"duplicate ID", key + "\n" + newKey(t, "a"), "", `line 3: duplicate key ID "a"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := Parse(strings.NewReader(tt.text))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse() returned error %v; want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() returned %s", err)
+			}
+			if k.Current() != tt.current {
+				t.Errorf("Current() = %q; want %q", k.Current(), tt.current)
+			}
+		})
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	k := mustParse(t, newKey(t, "a"))
+
+	tests := []struct {
+		name      string
+		plaintext []byte
+	}{
+		{"text", []byte("An old silent pond...")},
+		{"empty", []byte{}},
+		{"binary", []byte{0, 1, 2, 255}},
+		{"large", bytes.Repeat([]byte("x"), 1<<20)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := k.Seal(tt.plaintext)
+			if err != nil {
+				t.Fatalf("Seal() returned %s", err)
+			}
+			if e.KeyID != "a" {
+				t.Errorf("sealed with key %q; want %q", e.KeyID, "a")
+			}
+			if len(tt.plaintext) > 0 && bytes.Contains(e.Ciphertext, tt.plaintext) {
+				t.Error("ciphertext contains the plaintext")
+			}
+			got, err := k.Open(e)
+			if err != nil {
+				t.Fatalf("Open() returned %s", err)
+			}
+			if !bytes.Equal(got, tt.plaintext) {
+				t.Errorf("Open() returned %d bytes; want the %d bytes sealed", len(got), len(tt.plaintext))
+			}
+		})
+	}
+
+	// Each value gets its own data key and nonce, so sealing the same value
+	// twice gives different envelopes.
+	e1, _ := k.Seal([]byte("same"))
+	e2, _ := k.Seal([]byte("same"))
+	if bytes.Equal(e1.Ciphertext, e2.Ciphertext) || bytes.Equal(e1.WrappedKey, e2.WrappedKey) {
+		t.Error("sealing the same value twice gave the same envelope")
+	}
+}
+
+func TestOpenFails(t *testing.T) {
+	k := mustParse(t, newKey(t, "a"))
+	e, err := k.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flip := func(b []byte) []byte {
+		c := append([]byte(nil), b...)
+		c[len(c)-1] ^= 1
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		keyring *Keyring
+		e       *Envelope
+		want    error
+	}{
+		{"unknown key ID", k, &Envelope{KeyID: "b", WrappedKey: e.WrappedKey, Ciphertext: e.Ciphertext}, ErrUnknownKey},
+		{"wrong key with the same ID", mustParse(t, newKey(t, "a")), e, nil},
+		{"tampered ciphertext", k, &Envelope{KeyID: "a", WrappedKey: e.WrappedKey, Ciphertext: flip(e.Ciphertext)}, nil},
+		{"tampered wrapped key", k, &Envelope{KeyID: "a", WrappedKey: flip(e.WrappedKey), Ciphertext: e.Ciphertext}, nil},
+		{"truncated ciphertext", k, &Envelope{KeyID: "a", WrappedKey: e.WrappedKey, Ciphertext: e.Ciphertext[:4]}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.keyring.Open(tt.e)
+			if err == nil {
+				t.Fatalf("Open() returned %q; want an error", got)
+			}
+			if tt.want != nil && err != tt.want {
+				t.Errorf("Open() returned %v; want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	oldLine, newLine := newKey(t, "2019-08"), newKey(t, "2019-09")
+	before := mustParse(t, oldLine)
+	e, err := before.Seal([]byte("sealed before rotation"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// After adding a new key at the top of the keyring, new values are sealed
+	// with it, and old values can still be opened.
+	rotated := mustParse(t, newLine+oldLine)
+	if rotated.Current() != "2019-09" {
+		t.Fatalf("Current() = %q after rotation; want %q", rotated.Current(), "2019-09")
+	}
+	if got, err := rotated.Open(e); err != nil || string(got) != "sealed before rotation" {
+		t.Errorf("Open() of an old value = %q, %v; want the plaintext", got, err)
+	}
+	e2, err := rotated.Seal([]byte("sealed after rotation"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e2.KeyID != "2019-09" {
+		t.Errorf("new value sealed with %q; want %q", e2.KeyID, "2019-09")
+	}
+
+	// Once the old key is removed, only values sealed with the new key can be
+	// opened, which is why they have to be re-encrypted first.
+	after := mustParse(t, newLine)
+	if _, err := after.Open(e); err != ErrUnknownKey {
+		t.Errorf("Open() of an old value without its key returned %v; want ErrUnknownKey", err)
+	}
+	if got, err := after.Open(e2); err != nil || string(got) != "sealed after rotation" {
+		t.Errorf("Open() of a new value = %q, %v; want the plaintext", got, err)
+	}
+}
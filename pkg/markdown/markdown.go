@@ -0,0 +1,39 @@
+package markdown
+
+import (
+	"github.com/microcosm-cc/bluemonday"
+	"gopkg.in/russross/blackfriday.v2"
+	"html/template"
+	"regexp"
+)
+
+// The policy is an allowlist of the elements and attributes which Markdown
+// can produce, along with the tables and strikethrough from blackfriday's
+// common extensions. Anything else, including raw HTML in the source, is
+// removed. In particular there are no <script> or <style> elements, no event
+// handler or style attributes, and links and images may only use http, https
+// or mailto URLs, so there's no way to run JavaScript.
+var policy = newPolicy()
+
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("p", "br", "hr", "h1", "h2", "h3", "h4", "h5", "h6", "blockquote", "pre", "code",
+		"em", "strong", "del", "sup", "sub", "ul", "ol", "li", "dl", "dt", "dd",
+		"table", "thead", "tbody", "tr", "th", "td")
+	p.AllowStandardURLs()
+	p.AllowAttrs("href", "title").OnElements("a")
+	p.AllowAttrs("src", "alt", "title").OnElements("img")
+	p.RequireNoFollowOnLinks(true)
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowAttrs("align").Matching(regexp.MustCompile(`^(left|center|right)$`)).OnElements("th", "td")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+	return p
+}
+
+// Render converts Markdown source to HTML and sanitizes it. The result is
+// returned as template.HTML so that html/template includes it as it is, which
+// is only safe because everything the policy doesn't allow has been removed.
+func Render(source string) template.HTML {
+	unsafe := blackfriday.Run([]byte(source))
+	return template.HTML(policy.SanitizeBytes(unsafe))
+}
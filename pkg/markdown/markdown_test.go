@@ -0,0 +1,75 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderRemovesScripts(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		// None of the forbidden strings may appear in the output,
+		// case-insensitively.
+		forbidden []string
+	}{
+		{"script element", "<script>alert(1)</script>", []string{"<script", "alert(1)"}},
+		{"script element in a paragraph", "Hello <script>alert(1)</script> world", []string{"<script"}},
+		{"script with attributes", `<script src="https://evil.example.com/x.js"></script>`, []string{"<script", "evil.example.com"}},
+		{"uppercase script", "<SCRIPT>alert(1)</SCRIPT>", []string{"<script"}},
+		{"style element", "<style>body { display: none }</style>", []string{"<style"}},
+		{"iframe", `<iframe src="https://evil.example.com"></iframe>`, []string{"<iframe"}},
+		{"javascript link", "[click me](javascript:alert(1))", []string{"javascript:"}},
+		{"uppercase javascript link", "[click me](JaVaScRiPt:alert(1))", []string{"javascript:"}},
+		{"encoded javascript link", "[click me](&#106;avascript:alert(1))", []string{"javascript:", "&#106;avascript"}},
+		{"javascript autolink", "<javascript:alert(1)>", []string{`href="javascript:`}},
+		{"data link", "[click me](data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==)", []string{"data:"}},
+		{"vbscript link", "[click me](vbscript:msgbox(1))", []string{"vbscript:"}},
+		{"javascript image", "![x](javascript:alert(1))", []string{"javascript:"}},
+		{"raw javascript link", `<a href="javascript:alert(1)">click me</a>`, []string{"javascript:"}},
+		{"onerror attribute", `<img src="x" onerror="alert(1)">`, []string{"onerror"}},
+		{"onclick attribute", `<p onclick="alert(1)">click me</p>`, []string{"onclick"}},
+		{"onmouseover on a link", `<a href="https://example.com" onmouseover="alert(1)">hover</a>`, []string{"onmouseover"}},
+		{"svg onload", `<svg onload="alert(1)"></svg>`, []string{"<svg", "onload"}},
+		{"style attribute", `<p style="background:url(javascript:alert(1))">x</p>`, []string{"style=", "javascript:"}},
+		{"form", `<form action="https://evil.example.com"><input name="password"></form>`, []string{"<form", "<input"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.ToLower(string(Render(tt.source)))
+			for _, s := range tt.forbidden {
+				if strings.Contains(got, strings.ToLower(s)) {
+					t.Errorf("Render(%q) = %q, which contains %q", tt.source, got, s)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderKeepsMarkdown(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"heading", "# Title", "<h1>Title</h1>"},
+		{"emphasis", "*em* and **strong**", "<em>em</em> and <strong>strong</strong>"},
+		{"https link", "[home](https://example.com)", `<a href="https://example.com" rel="nofollow">home</a>`},
+		{"mailto link", "[mail](mailto:alice@example.com)", `href="mailto:alice@example.com"`},
+		{"image", "![alt](https://example.com/a.png)", `<img src="https://example.com/a.png" alt="alt"`},
+		{"code block", "```go\nfmt.Println(1)\n```", `<code class="language-go">`},
+		{"table", "a | b\n--- | ---\n1 | 2", "<td>1</td>"},
+		{"strikethrough", "~~gone~~", "<del>gone</del>"},
+		{"escaped html in code", "`<script>`", "<code>&lt;script&gt;</code>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(Render(tt.source))
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("Render(%q) = %q; want it to contain %q", tt.source, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package migrate
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migration holds the statements parsed from a single goose-formatted SQL
+// file. The version is the numeric prefix of the file name.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// Migrator applies migrations to a database and records the versions which
+// have been applied in the schema_migrations table. The versions applied by
+// goose are imported from its goose_db_version table the first time.
+type Migrator struct {
+	DB         *sql.DB
+	Driver     string
+	Migrations []*Migration
+}
+
+// New parses the *.sql files at the top level of fsys and returns a Migrator
+// for them, sorted by version.
+func New(db *sql.DB, driver string, fsys fs.FS) (*Migrator, error) {
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	m := &Migrator{DB: db, Driver: driver}
+	for _, name := range names {
+		f, err := fsys.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		migration, err := parse(path.Base(name), f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		m.Migrations = append(m.Migrations, migration)
+	}
+	sort.Slice(m.Migrations, func(i, j int) bool {
+		return m.Migrations[i].Version < m.Migrations[j].Version
+	})
+	return m, nil
+}
+
+// The parse() function splits a goose-formatted file into its Up and Down
+// statements. Statements end with a semicolon at the end of a line, unless
+// they're wrapped in StatementBegin and StatementEnd annotations.
+func parse(name string, r io.Reader) (*Migration, error) {
+	prefix := strings.SplitN(name, "_", 2)[0]
+	version, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: invalid version in file name %q", name)
+	}
+	m := &Migration{Version: version, Name: name}
+
+	var section *[]string
+	var buf strings.Builder
+	inBlock := false
+	flush := func() {
+		stmt := strings.TrimSpace(buf.String())
+		if stmt != "" && section != nil {
+			*section = append(*section, stmt)
+		}
+		buf.Reset()
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		switch trimmed {
+		case "-- +goose Up":
+			flush()
+			section = &m.Up
+			continue
+		case "-- +goose Down":
+			flush()
+			section = &m.Down
+			continue
+		case "-- +goose StatementBegin":
+			flush()
+			inBlock = true
+			continue
+		case "-- +goose StatementEnd":
+			inBlock = false
+			flush()
+			continue
+		}
+		if strings.HasPrefix(trimmed, "--") && !inBlock {
+			continue
+		}
+		buf.WriteString(line)
+		buf.WriteString("\n")
+		if !inBlock && strings.HasSuffix(trimmed, ";") {
+			flush()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// The final statement in a file doesn't need a trailing semicolon.
+	flush()
+	if len(m.Up) == 0 {
+		return nil, fmt.Errorf("migrate: %s has no Up statements", name)
+	}
+	return m, nil
+}
+
+// The placeholder() helper returns the nth bind parameter for the driver.
+func (m *Migrator) placeholder(n int) string {
+	if m.Driver == "postgres" {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+// The applied() method creates the schema_migrations table if necessary, and
+// returns the versions that have already been applied along with the time
+// they were applied.
+func (m *Migrator) applied() (map[int64]time.Time, error) {
+	exists, err := m.tableExists("schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err = m.createTable(); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := m.DB.Query("SELECT version, applied FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var t time.Time
+		if err := rows.Scan(&version, &t); err != nil {
+			return nil, err
+		}
+		versions[version] = t
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// The tableExists() method reports whether the named table exists.
+func (m *Migrator) tableExists(name string) (bool, error) {
+	var stmt string
+	switch m.Driver {
+	case "sqlite3":
+		stmt = `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`
+	case "postgres":
+		stmt = `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1`
+	default:
+		stmt = `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?`
+	}
+	var n int
+	err := m.DB.QueryRow(stmt, name).Scan(&n)
+	return n > 0, err
+}
+
+// The createTable() method creates the schema_migrations table. Databases
+// which were migrated with the goose command before the web application could
+// apply its own migrations have their versions in goose's goose_db_version
+// table instead, so those are copied across, and the migrations aren't run a
+// second time.
+func (m *Migrator) createTable() error {
+	goose, err := m.tableExists("goose_db_version")
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	stmt := `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT    NOT NULL PRIMARY KEY,
+    applied TIMESTAMP NOT NULL
+)`
+	if _, err = tx.Exec(stmt); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if goose {
+		if err = m.importGoose(tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrate: importing goose_db_version: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// The importGoose() method copies the applied versions from goose's
+// goose_db_version table into schema_migrations. Goose adds a row each time a
+// migration is applied or rolled back, so the latest row for each version says
+// whether it's currently applied. The row for version 0 is goose's own
+// starting point, and isn't a migration.
+func (m *Migrator) importGoose(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT version_id, is_applied, tstamp FROM goose_db_version ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	versions := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var isApplied bool
+		var t sql.NullTime
+		if err := rows.Scan(&version, &isApplied, &t); err != nil {
+			return err
+		}
+		switch {
+		case version == 0:
+		case !isApplied:
+			delete(versions, version)
+		case t.Valid:
+			versions[version] = t.Time.UTC()
+		default:
+			versions[version] = time.Now().UTC()
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	stmt := fmt.Sprintf("INSERT INTO schema_migrations (version, applied) VALUES(%s, %s)",
+		m.placeholder(1), m.placeholder(2))
+	for version, t := range versions {
+		if _, err := tx.Exec(stmt, version, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pending returns the migrations which haven't been applied yet.
+func (m *Migrator) Pending() ([]*Migration, error) {
+	versions, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	var pending []*Migration
+	for _, migration := range m.Migrations {
+		if _, ok := versions[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies all pending migrations in version order. Each migration is run in
+// its own transaction together with the insert into schema_migrations. Note
+// that MySQL implicitly commits DDL statements, so a failed migration there may
+// need cleaning up by hand.
+func (m *Migrator) Up() error {
+	pending, err := m.Pending()
+	if err != nil {
+		return err
+	}
+	stmt := fmt.Sprintf("INSERT INTO schema_migrations (version, applied) VALUES(%s, %s)",
+		m.placeholder(1), m.placeholder(2))
+	for _, migration := range pending {
+		err := m.run(migration.Name, migration.Up, stmt, migration.Version, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Down rolls back the most recently applied migration.
+func (m *Migrator) Down() error {
+	versions, err := m.applied()
+	if err != nil {
+		return err
+	}
+	for i := len(m.Migrations) - 1; i >= 0; i-- {
+		migration := m.Migrations[i]
+		if _, ok := versions[migration.Version]; !ok {
+			continue
+		}
+		stmt := fmt.Sprintf("DELETE FROM schema_migrations WHERE version = %s", m.placeholder(1))
+		return m.run(migration.Name, migration.Down, stmt, migration.Version)
+	}
+	return fmt.Errorf("migrate: no migrations to roll back")
+}
+
+// The run() method executes the statements of a migration, followed by the
+// bookkeeping statement, in a single transaction.
+func (m *Migrator) run(name string, statements []string, record string, args ...interface{}) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrate: %s: %v", name, err)
+		}
+	}
+	if _, err := tx.Exec(record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Status writes a table of all migrations, and when they were applied, to w.
+func (m *Migrator) Status(w io.Writer) error {
+	versions, err := m.applied()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "    Applied At                  Migration")
+	fmt.Fprintln(w, "    =======================================")
+	for _, migration := range m.Migrations {
+		appliedAt := "Pending                 "
+		if t, ok := versions[migration.Version]; ok {
+			appliedAt = t.Format(time.ANSIC)
+		}
+		fmt.Fprintf(w, "    %s -- %s\n", appliedAt, migration.Name)
+	}
+	return nil
+}
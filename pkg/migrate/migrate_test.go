@@ -0,0 +1,253 @@
+package migrate
+
+import (
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		text     string
+		wantUp   []string
+		wantDown []string
+		wantErr  string
+	}{
+		{
+			name:     "up and down",
+			file:     "20190709073204_create_db.sql",
+			text:     "-- +goose Up\nCREATE TABLE a (id INTEGER);\n-- +goose Down\nDROP TABLE a;\n",
+			wantUp:   []string{"CREATE TABLE a (id INTEGER);"},
+			wantDown: []string{"DROP TABLE a;"},
+		},
+		{
+			name: "several statements",
+			file: "1_several.sql",
+			text: "-- +goose Up\nCREATE TABLE a (id INTEGER);\nCREATE TABLE b (id INTEGER);\n" +
+				"-- +goose Down\nDROP TABLE b;\nDROP TABLE a;\n",
+			wantUp:   []string{"CREATE TABLE a (id INTEGER);", "CREATE TABLE b (id INTEGER);"},
+			wantDown: []string{"DROP TABLE b;", "DROP TABLE a;"},
+		},
+		{
+			name:   "statement over several lines",
+			file:   "1_multiline.sql",
+			text:   "-- +goose Up\nCREATE TABLE a\n(\n    id INTEGER\n);\n",
+			wantUp: []string{"CREATE TABLE a\n(\n    id INTEGER\n);"},
+		},
+		{
+			name:   "comments are skipped",
+			file:   "1_comments.sql",
+			text:   "-- +goose Up\n-- Explain the table.\nCREATE TABLE a (id INTEGER);\n  -- indented comment\n",
+			wantUp: []string{"CREATE TABLE a (id INTEGER);"},
+		},
+		{
+			name:   "semicolon inside a line",
+			file:   "1_values.sql",
+			text:   "-- +goose Up\nINSERT INTO a (s) VALUES ('x;y');\n",
+			wantUp: []string{"INSERT INTO a (s) VALUES ('x;y');"},
+		},
+		{
+			name: "statement block",
+			file: "1_block.sql",
+			text: "-- +goose Up\n-- +goose StatementBegin\nCREATE FUNCTION f() RETURNS trigger AS $$\nBEGIN\n    -- a comment in the body\n    RETURN NEW;\nEND;\n$$ LANGUAGE plpgsql;\n-- +goose StatementEnd\nCREATE TABLE a (id INTEGER);\n",
+			wantUp: []string{
+				"CREATE FUNCTION f() RETURNS trigger AS $$\nBEGIN\n    -- a comment in the body\n    RETURN NEW;\nEND;\n$$ LANGUAGE plpgsql;",
+				"CREATE TABLE a (id INTEGER);",
+			},
+		},
+		{
+			name:     "final statement without a semicolon",
+			file:     "1_last.sql",
+			text:     "-- +goose Up\nCREATE TABLE a (id INTEGER)\n-- +goose Down\nDROP TABLE a",
+			wantUp:   []string{"CREATE TABLE a (id INTEGER)"},
+			wantDown: []string{"DROP TABLE a"},
+		},
+		{
+			name:   "no down section",
+			file:   "1_up.sql",
+			text:   "-- +goose Up\nCREATE TABLE a (id INTEGER);\n",
+			wantUp: []string{"CREATE TABLE a (id INTEGER);"},
+		},
+		{
+			name:    "no up section",
+			file:    "1_down.sql",
+			text:    "-- +goose Down\nDROP TABLE a;\n",
+			wantErr: "has no Up statements",
+		},
+		{
+			name:    "statements outside a section",
+			file:    "1_none.sql",
+			text:    "CREATE TABLE a (id INTEGER);\n",
+			wantErr: "has no Up statements",
+		},
+		{
+			name:    "no version",
+			file:    "create_db.sql",
+			text:    "-- +goose Up\nCREATE TABLE a (id INTEGER);\n",
+			wantErr: "invalid version",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := parse(tt.file, strings.NewReader(tt.text))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parse() returned error %v; want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse() returned %s", err)
+			}
+			if m.Name != tt.file {
+				t.Errorf("Name = %q; want %q", m.Name, tt.file)
+			}
+			if !reflect.DeepEqual(m.Up, tt.wantUp) {
+				t.Errorf("Up = %q; want %q", m.Up, tt.wantUp)
+			}
+			if !reflect.DeepEqual(m.Down, tt.wantDown) {
+				t.Errorf("Down = %q; want %q", m.Down, tt.wantDown)
+			}
+		})
+	}
+}
+
+// testMigrations creates one table for each of three versions.
+var testMigrations = fstest.MapFS{
+	"3_create_c.sql": {Data: []byte("-- +goose Up\nCREATE TABLE c (id INTEGER);\n-- +goose Down\nDROP TABLE c;\n")},
+	"1_create_a.sql": {Data: []byte("-- +goose Up\nCREATE TABLE a (id INTEGER);\n-- +goose Down\nDROP TABLE a;\n")},
+	"2_create_b.sql": {Data: []byte("-- +goose Up\nCREATE TABLE b (id INTEGER);\n-- +goose Down\nDROP TABLE b;\n")},
+	"README.md":      {Data: []byte("Not a migration.")},
+}
+
+// The openDB() helper returns a new in-memory SQLite database.
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each connection to ":memory:" gets its own database.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// The newMigrator() helper returns a Migrator for testMigrations.
+func newMigrator(t *testing.T, db *sql.DB) *Migrator {
+	t.Helper()
+	m, err := New(db, "sqlite3", testMigrations)
+	if err != nil {
+		t.Fatalf("New() returned %s", err)
+	}
+	return m
+}
+
+// The pendingVersions() helper returns the versions of the pending migrations.
+func pendingVersions(t *testing.T, m *Migrator) []int64 {
+	t.Helper()
+	pending, err := m.Pending()
+	if err != nil {
+		t.Fatalf("Pending() returned %s", err)
+	}
+	versions := []int64{}
+	for _, migration := range pending {
+		versions = append(versions, migration.Version)
+	}
+	return versions
+}
+
+func TestUpDown(t *testing.T) {
+	db := openDB(t)
+	m := newMigrator(t, db)
+
+	if got := pendingVersions(t, m); !reflect.DeepEqual(got, []int64{1, 2, 3}) {
+		t.Fatalf("pending versions = %v; want [1 2 3] in order", got)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatalf("Up() returned %s", err)
+	}
+	if got := pendingVersions(t, m); len(got) != 0 {
+		t.Fatalf("pending versions after Up() = %v; want none", got)
+	}
+	if _, err := db.Exec("INSERT INTO c (id) VALUES (1)"); err != nil {
+		t.Errorf("table c wasn't created: %s", err)
+	}
+
+	// Running Up() again has nothing to do.
+	if err := m.Up(); err != nil {
+		t.Fatalf("second Up() returned %s", err)
+	}
+
+	if err := m.Down(); err != nil {
+		t.Fatalf("Down() returned %s", err)
+	}
+	if got := pendingVersions(t, m); !reflect.DeepEqual(got, []int64{3}) {
+		t.Errorf("pending versions after Down() = %v; want [3]", got)
+	}
+	if _, err := db.Exec("INSERT INTO c (id) VALUES (1)"); err == nil {
+		t.Error("table c wasn't dropped")
+	}
+
+	var status strings.Builder
+	if err := m.Status(&status); err != nil {
+		t.Fatalf("Status() returned %s", err)
+	}
+	if !strings.Contains(status.String(), "Pending                  -- 3_create_c.sql") {
+		t.Errorf("Status() doesn't show 3_create_c.sql as pending:\n%s", status.String())
+	}
+}
+
+func TestImportGoose(t *testing.T) {
+	db := openDB(t)
+	// This is the table and history goose would leave after applying the first
+	// three migrations and then rolling back the third.
+	stmts := []string{
+		`CREATE TABLE goose_db_version (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    version_id INTEGER NOT NULL,
+    is_applied INTEGER NOT NULL,
+    tstamp TIMESTAMP DEFAULT (datetime('now'))
+)`,
+		`INSERT INTO goose_db_version (version_id, is_applied) VALUES (0, 1)`,
+		`INSERT INTO goose_db_version (version_id, is_applied) VALUES (1, 1)`,
+		`INSERT INTO goose_db_version (version_id, is_applied) VALUES (2, 1)`,
+		`INSERT INTO goose_db_version (version_id, is_applied) VALUES (3, 1)`,
+		`INSERT INTO goose_db_version (version_id, is_applied) VALUES (3, 0)`,
+		`CREATE TABLE a (id INTEGER)`,
+		`CREATE TABLE b (id INTEGER)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := newMigrator(t, db)
+	if got := pendingVersions(t, m); !reflect.DeepEqual(got, []int64{3}) {
+		t.Fatalf("pending versions = %v; want [3]", got)
+	}
+	// Running the imported migrations again would fail, as the tables exist.
+	if err := m.Up(); err != nil {
+		t.Fatalf("Up() returned %s", err)
+	}
+
+	// The goose versions are only imported once, so rolling back with the new
+	// runner isn't undone by importing them again.
+	if err := m.Down(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Down(); err != nil {
+		t.Fatal(err)
+	}
+	m = newMigrator(t, db)
+	if got := pendingVersions(t, m); !reflect.DeepEqual(got, []int64{2, 3}) {
+		t.Errorf("pending versions after rolling back = %v; want [2 3]", got)
+	}
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter counts events, such as failed password attempts, separately for
+// each key. A key is allowed at most Limit events in each Window, which starts
+// at the key's first event. It's safe for concurrent use.
+type Limiter struct {
+	Limit  int
+	Window time.Duration
+
+	mu      sync.Mutex
+	windows map[string]*window
+	swept   time.Time
+}
+
+type window struct {
+	start time.Time
+	count int
+}
+
+// New returns a Limiter allowing limit events per key in each period.
+func New(limit int, period time.Duration) *Limiter {
+	return &Limiter{
+		Limit:   limit,
+		Window:  period,
+		windows: make(map[string]*window),
+	}
+}
+
+// Take records an event for the key if it has had fewer than Limit events in
+// its current window, and reports whether it did. Checking and counting in
+// one step means that concurrent callers can't all get past the limit.
+func (l *Limiter) Take(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+	w := l.current(key, now)
+	if w == nil {
+		w = &window{start: now}
+		l.windows[key] = w
+	}
+	if w.count >= l.Limit {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// Reset forgets the events recorded for the key.
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.windows, key)
+}
+
+// The current() method returns the key's window, or nil if it has none or it
+// has ended. The caller must hold the lock.
+func (l *Limiter) current(key string, now time.Time) *window {
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.Window {
+		return nil
+	}
+	return w
+}
+
+// The sweep() method deletes the windows which have ended, at most once per
+// Window, so that keys which are never seen again don't accumulate forever.
+// The caller must hold the lock.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < l.Window {
+		return
+	}
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= l.Window {
+			delete(l.windows, key)
+		}
+	}
+	l.swept = now
+}
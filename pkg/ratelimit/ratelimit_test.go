@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTake(t *testing.T) {
+	l := New(3, time.Hour)
+
+	for i := 1; i <= 3; i++ {
+		if !l.Take("a") {
+			t.Fatalf("Take() of event %d = false; want true", i)
+		}
+	}
+	if l.Take("a") {
+		t.Error("Take() over the limit = true; want false")
+	}
+	// Each key has its own count.
+	if !l.Take("b") {
+		t.Error("Take() for another key = false; want true")
+	}
+}
+
+func TestTakeWindowRollover(t *testing.T) {
+	l := New(1, 50*time.Millisecond)
+
+	if !l.Take("a") {
+		t.Fatal("first Take() = false; want true")
+	}
+	if l.Take("a") {
+		t.Fatal("second Take() in the same window = true; want false")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if !l.Take("a") {
+		t.Error("Take() after the window ended = false; want true")
+	}
+	if l.Take("a") {
+		t.Error("second Take() in the new window = true; want false")
+	}
+}
+
+func TestReset(t *testing.T) {
+	l := New(1, time.Hour)
+
+	l.Take("a")
+	l.Take("b")
+	l.Reset("a")
+	if !l.Take("a") {
+		t.Error("Take() after Reset() = false; want true")
+	}
+	if l.Take("b") {
+		t.Error("Reset() of one key reset another")
+	}
+	// Resetting a key which has no events does nothing.
+	l.Reset("unknown")
+}
+
+func TestSweep(t *testing.T) {
+	l := New(1, 50*time.Millisecond)
+
+	l.Take("old")
+	time.Sleep(100 * time.Millisecond)
+	// Taking an event for any key sweeps away the windows which have ended.
+	l.Take("new")
+	if _, ok := l.windows["old"]; ok {
+		t.Error("the ended window wasn't swept")
+	}
+	if _, ok := l.windows["new"]; !ok {
+		t.Error("the current window was swept")
+	}
+
+	// Sweeping happens at most once per window, so a window which ends
+	// straight after a sweep is kept until the next one.
+	l.swept = time.Now()
+	l.windows["ended"] = &window{start: time.Now().Add(-time.Hour), count: 1}
+	l.Take("new")
+	if _, ok := l.windows["ended"]; !ok {
+		t.Error("windows were swept twice in one window")
+	}
+}
@@ -0,0 +1,176 @@
+package syntax
+
+import (
+	"github.com/alecthomas/chroma"
+	"github.com/alecthomas/chroma/lexers"
+	"strings"
+)
+
+// Language is one of the languages offered on the snippet forms. Name is the
+// chroma lexer name stored with the snippet, Label is shown to the user, and
+// Extension is used for the names of downloaded files.
+type Language struct {
+	Name      string
+	Label     string
+	Extension string
+}
+
+// Languages lists the languages offered on the snippet forms. Snippets with
+// no language have it detected from their content when they're displayed.
+var Languages = []Language{
+	{"bash", "Bash", ".sh"},
+	{"c", "C", ".c"},
+	{"cpp", "C++", ".cpp"},
+	{"csharp", "C#", ".cs"},
+	{"css", "CSS", ".css"},
+	{"diff", "Diff", ".diff"},
+	{"docker", "Dockerfile", ".dockerfile"},
+	{"go", "Go", ".go"},
+	{"html", "HTML", ".html"},
+	{"java", "Java", ".java"},
+	{"javascript", "JavaScript", ".js"},
+	{"json", "JSON", ".json"},
+	{"kotlin", "Kotlin", ".kt"},
+	{"lua", "Lua", ".lua"},
+	{"makefile", "Makefile", ".mk"},
+	{"markdown", "Markdown", ".md"},
+	{"php", "PHP", ".php"},
+	{"python", "Python", ".py"},
+	{"ruby", "Ruby", ".rb"},
+	{"rust", "Rust", ".rs"},
+	{"sql", "SQL", ".sql"},
+	{"swift", "Swift", ".swift"},
+	{"toml", "TOML", ".toml"},
+	{"typescript", "TypeScript", ".ts"},
+	{"xml", "XML", ".xml"},
+	{"yaml", "YAML", ".yaml"},
+	{"plaintext", "Plain text", ".txt"},
+}
+
+// Supported reports whether name is one of the Languages.
+func Supported(name string) bool {
+	for _, l := range Languages {
+		if l.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Detect returns the name of the language which the code looks most like, or
+// "plaintext" if it isn't recognised.
+func Detect(code string) string {
+	lexer := lexers.Analyse(code)
+	if lexer == nil {
+		return "plaintext"
+	}
+	return languageName(lexer)
+}
+
+// Extension returns the file name extension for a language name, which is
+// ".txt" if it isn't one of the Languages.
+func Extension(name string) string {
+	for _, l := range Languages {
+		if l.Name == name {
+			return l.Extension
+		}
+	}
+	return ".txt"
+}
+
+// Token is a piece of a line of code. Class is the CSS class for its kind of
+// token, such as "k" for keywords, and is empty for plain text.
+type Token struct {
+	Class string
+	Text  string
+}
+
+// Line is a single line of highlighted code, numbered from 1. The Tokens
+// don't include the line's newline.
+type Line struct {
+	Number int
+	Tokens []Token
+}
+
+// Highlight splits code into lines of tokens, using the lexer for the named
+// language, or the best guess from the code itself if name is empty. It
+// returns the name of the language that was used, which is "plaintext" if no
+// lexer recognised the code.
+//
+// The tokens are plain text, to be escaped by html/template like any other
+// value, so highlighting can't introduce markup of its own.
+func Highlight(code, name string) ([]Line, string, error) {
+	var lexer chroma.Lexer
+	if name != "" {
+		lexer = lexers.Get(name)
+	} else {
+		lexer = lexers.Analyse(code)
+	}
+	if lexer == nil {
+		lexer = lexers.Get("plaintext")
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	lexer = chroma.Coalesce(lexer)
+
+	iterator, err := lexer.Tokenise(nil, code)
+	if err != nil {
+		return nil, "", err
+	}
+	var lines []Line
+	for i, tokens := range chroma.SplitTokensIntoLines(iterator.Tokens()) {
+		line := Line{Number: i + 1}
+		for _, t := range tokens {
+			text := strings.TrimRight(t.Value, "\r\n")
+			if text == "" {
+				continue
+			}
+			line.Tokens = append(line.Tokens, Token{Class: class(t.Type), Text: text})
+		}
+		lines = append(lines, line)
+	}
+	return lines, languageName(lexer), nil
+}
+
+// The class() function returns the CSS classes for a token type. These are
+// chroma's short class names, such as "s2" for a double-quoted string, along
+// with the class for its broader category ("s" for any string) so that the
+// stylesheet doesn't have to list every type.
+func class(t chroma.TokenType) string {
+	exact := chroma.StandardTypes[t]
+	sub := chroma.StandardTypes[t.SubCategory()]
+	if sub == "" || sub == exact {
+		return exact
+	}
+	if exact == "" {
+		return sub
+	}
+	return sub + " " + exact
+}
+
+// The languageName() function returns the name which selects the lexer, in
+// the same form as the Languages.
+func languageName(lexer chroma.Lexer) string {
+	config := lexer.Config()
+	if config == nil {
+		return "plaintext"
+	}
+	for _, alias := range config.Aliases {
+		if Supported(alias) {
+			return alias
+		}
+	}
+	return strings.ToLower(config.Name)
+}
+
+// Label returns the label to show for a language name, which is the name
+// itself if it isn't one of the Languages.
+func Label(name string) string {
+	for _, l := range Languages {
+		if l.Name == name {
+			return l.Label
+		}
+	}
+	return name
+}
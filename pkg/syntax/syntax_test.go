@@ -0,0 +1,110 @@
+package syntax
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+	"testing"
+)
+
+// The text() helper joins the text of a line's tokens back together.
+func text(l Line) string {
+	var b strings.Builder
+	for _, t := range l.Tokens {
+		b.WriteString(t.Text)
+	}
+	return b.String()
+}
+
+func TestHighlightLines(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want []string
+	}{
+		{"lf", "a := 1\nb := 2\n", []string{"a := 1", "b := 2"}},
+		{"crlf", "a := 1\r\nb := 2\r\n", []string{"a := 1", "b := 2"}},
+		{"no trailing newline", "a := 1\nb := 2", []string{"a := 1", "b := 2"}},
+		{"blank line", "a := 1\n\nb := 2\n", []string{"a := 1", "", "b := 2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, _, err := Highlight(tt.code, "go")
+			if err != nil {
+				t.Fatalf("Highlight() returned %s", err)
+			}
+			if len(lines) != len(tt.want) {
+				t.Fatalf("Highlight() returned %d lines; want %d", len(lines), len(tt.want))
+			}
+			for i, l := range lines {
+				if l.Number != i+1 {
+					t.Errorf("line %d is numbered %d", i+1, l.Number)
+				}
+				if got := text(l); got != tt.want[i] {
+					t.Errorf("line %d = %q; want %q", l.Number, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHighlightLanguage(t *testing.T) {
+	tests := []struct {
+		code string
+		name string
+		want string
+	}{
+		{"package main\n", "go", "go"},
+		{"SELECT 1;\n", "sql", "sql"},
+		{"whatever\n", "no-such-language", "plaintext"},
+		{"just some words\n", "", "plaintext"},
+	}
+
+	for _, tt := range tests {
+		lines, got, err := Highlight(tt.code, tt.name)
+		if err != nil {
+			t.Fatalf("Highlight(%q) returned %s", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("Highlight(%q) used %q; want %q", tt.name, got, tt.want)
+		}
+		if len(lines) != 1 || text(lines[0])+"\n" != tt.code {
+			t.Errorf("Highlight(%q) changed the code to %+v", tt.name, lines)
+		}
+	}
+}
+
+func TestHighlightEscaped(t *testing.T) {
+	// The code template doesn't call any functions, but the others in
+	// base.html need them to exist before it can be parsed.
+	stub := func() string { return "" }
+	fm := template.FuncMap{
+		"excerpt":   stub,
+		"highlight": stub,
+		"humanDate": stub,
+		"languages": stub,
+		"langLabel": stub}
+	ts, err := template.New("").Funcs(fm).ParseFiles("../../ui/html/base.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"html", "javascript", "plaintext"} {
+		lines, _, err := Highlight(`<script>alert("x")</script>`, name)
+		if err != nil {
+			t.Fatalf("Highlight(%q) returned %s", name, err)
+		}
+		buf := new(bytes.Buffer)
+		if err := ts.ExecuteTemplate(buf, "code", struct{ Lines []Line }{lines}); err != nil {
+			t.Fatal(err)
+		}
+		// The template loads lines.js with a script tag of its own, so only
+		// look at the code itself.
+		body := buf.String()
+		body = body[:strings.Index(body, "</code>")]
+		if strings.Contains(body, "<script") || !strings.Contains(body, "&lt;") {
+			t.Errorf("%s code wasn't escaped:\n%s", name, body)
+		}
+	}
+}
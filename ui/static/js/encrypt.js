@@ -0,0 +1,132 @@
+// Encrypted snippets are encrypted in the browser with AES-GCM before they're
+// submitted, and decrypted in the browser when they're viewed. The key is kept
+// in the URL fragment (the part after the #), which browsers never send to the
+// server, so the server only ever sees the ciphertext.
+//
+// The stored content is the base64 encoding of the 12 byte IV followed by the
+// ciphertext, and the key is the base64url encoding of the raw 256 bit key.
+(function () {
+  'use strict';
+
+  var IV_LENGTH = 12;
+
+  function toBase64(bytes) {
+    var s = '';
+    for (var i = 0; i < bytes.length; i++) {
+      s += String.fromCharCode(bytes[i]);
+    }
+    return btoa(s);
+  }
+
+  function fromBase64(s) {
+    var bin = atob(s);
+    var bytes = new Uint8Array(bin.length);
+    for (var i = 0; i < bin.length; i++) {
+      bytes[i] = bin.charCodeAt(i);
+    }
+    return bytes;
+  }
+
+  function toBase64URL(bytes) {
+    return toBase64(bytes).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+  }
+
+  function fromBase64URL(s) {
+    s = s.replace(/-/g, '+').replace(/_/g, '/');
+    while (s.length % 4) {
+      s += '=';
+    }
+    return fromBase64(s);
+  }
+
+  function supported() {
+    return window.crypto && window.crypto.subtle && window.TextEncoder;
+  }
+
+  // encrypt() resolves to the content to submit and the key for the fragment.
+  function encrypt(plaintext) {
+    var iv = crypto.getRandomValues(new Uint8Array(IV_LENGTH));
+    var key;
+    return crypto.subtle.generateKey({name: 'AES-GCM', length: 256}, true, ['encrypt'])
+      .then(function (k) {
+        key = k;
+        return crypto.subtle.encrypt({name: 'AES-GCM', iv: iv}, key, new TextEncoder().encode(plaintext));
+      })
+      .then(function (ciphertext) {
+        var payload = new Uint8Array(IV_LENGTH + ciphertext.byteLength);
+        payload.set(iv);
+        payload.set(new Uint8Array(ciphertext), IV_LENGTH);
+        return crypto.subtle.exportKey('raw', key).then(function (raw) {
+          return {content: toBase64(payload), key: toBase64URL(new Uint8Array(raw))};
+        });
+      });
+  }
+
+  // decrypt() resolves to the plaintext, or rejects if the key is wrong.
+  function decrypt(content, keyString) {
+    var payload = fromBase64(content);
+    return crypto.subtle.importKey('raw', fromBase64URL(keyString), 'AES-GCM', false, ['decrypt'])
+      .then(function (key) {
+        return crypto.subtle.decrypt({name: 'AES-GCM', iv: payload.slice(0, IV_LENGTH)}, key, payload.slice(IV_LENGTH));
+      })
+      .then(function (plaintext) {
+        return new TextDecoder().decode(plaintext);
+      });
+  }
+
+  // On the new snippet form, encrypt the content when the box is ticked. The
+  // key is added to the form's action URL, and because the redirect to the
+  // new snippet doesn't have a fragment of its own the browser carries the
+  // key over to it.
+  var form = document.querySelector('form[data-encrypt]');
+  if (form) {
+    var checkbox = form.querySelector('input[name="encrypted"]');
+    var textarea = form.querySelector('textarea[name="content"]');
+
+    // If the server sent the form back with a validation failure, the
+    // content is the ciphertext we submitted, and the key is still in our
+    // URL, so we can put the original text back.
+    var key = location.hash.slice(1);
+    if (checkbox.checked && key && supported()) {
+      decrypt(textarea.value, key).then(function (plaintext) {
+        textarea.value = plaintext;
+      }, function () {});
+    }
+
+    form.addEventListener('submit', function (event) {
+      if (!checkbox.checked) {
+        return;
+      }
+      event.preventDefault();
+      if (!supported()) {
+        alert('Your browser doesn\'t support encryption. Untick "Encrypt in my browser" to save the snippet unencrypted.');
+        return;
+      }
+      encrypt(textarea.value).then(function (result) {
+        textarea.value = result.content;
+        form.action = form.action.split('#')[0] + '#' + result.key;
+        // Calling submit() doesn't fire the submit event again.
+        form.submit();
+      }, function () {
+        alert('The snippet could not be encrypted.');
+      });
+    });
+  }
+
+  // On the snippet page, decrypt the content with the key from the URL.
+  var code = document.querySelector('[data-ciphertext]');
+  if (code) {
+    var fragment = location.hash.slice(1);
+    if (!fragment) {
+      code.textContent = 'This snippet is encrypted, and the link you followed doesn\'t include the key needed to read it.';
+    } else if (!supported()) {
+      code.textContent = 'This snippet is encrypted, and your browser doesn\'t support decrypting it.';
+    } else {
+      decrypt(code.getAttribute('data-ciphertext'), fragment).then(function (plaintext) {
+        code.textContent = plaintext;
+      }, function () {
+        code.textContent = 'This snippet could not be decrypted. Check that you have the whole link, including the part after the #.';
+      });
+    }
+  }
+})();
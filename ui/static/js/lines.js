@@ -0,0 +1,68 @@
+// Highlighted code has an anchor for each line, so that a link ending in #L12
+// points at line 12. A range of lines can be linked with #L12-L20, which is
+// made by clicking the first line number and shift-clicking the last. The
+// fragment is only used if it matches that form exactly, so it can't be
+// confused with the key of an encrypted snippet.
+(function () {
+  'use strict';
+
+  var RANGE = /^#L(\d+)(?:-L(\d+))?$/;
+
+  function lines(hash) {
+    var match = RANGE.exec(hash);
+    if (!match) {
+      return null;
+    }
+    var start = parseInt(match[1], 10);
+    var end = match[2] ? parseInt(match[2], 10) : start;
+    return start <= end ? {start: start, end: end} : {start: end, end: start};
+  }
+
+  function highlight(scroll) {
+    var selected = document.querySelectorAll('.code .line.selected');
+    for (var i = 0; i < selected.length; i++) {
+      selected[i].classList.remove('selected');
+    }
+    var range = lines(location.hash);
+    if (!range) {
+      return;
+    }
+    var first = null;
+    for (var n = range.start; n <= range.end; n++) {
+      var line = document.getElementById('L' + n);
+      if (!line) {
+        break;
+      }
+      line.classList.add('selected');
+      first = first || line;
+    }
+    if (first && scroll) {
+      first.scrollIntoView({block: 'center'});
+    }
+  }
+
+  document.addEventListener('click', function (event) {
+    var link = event.target.closest ? event.target.closest('.code a.ln') : null;
+    if (!link) {
+      return;
+    }
+    event.preventDefault();
+    var hash = link.getAttribute('href');
+    var current = lines(location.hash);
+    if (event.shiftKey && current) {
+      var n = parseInt(hash.slice(2), 10);
+      var start = Math.min(current.start, n);
+      var end = Math.max(current.start, n);
+      hash = start === end ? '#L' + start : '#L' + start + '-L' + end;
+    }
+    // Replacing the state rather than setting location.hash stops the browser
+    // jumping to the line that was clicked.
+    history.replaceState(null, '', hash);
+    highlight(false);
+  });
+
+  window.addEventListener('hashchange', function () {
+    highlight(true);
+  });
+  highlight(true);
+})();
@@ -0,0 +1,74 @@
+// The new snippet form has Write and Preview tabs. The preview is rendered by
+// the server, which sanitizes the HTML in the same way as when the snippet is
+// shown, so the response can be put straight into the page. The tabs are
+// hidden unless this script runs.
+(function () {
+  'use strict';
+
+  var form = document.querySelector('form[data-encrypt]');
+  var tabs = form && form.querySelector('.tabs');
+  if (!tabs || !window.fetch) {
+    return;
+  }
+  var textarea = form.querySelector('textarea[name="content"]');
+  var preview = form.querySelector('.preview');
+  var language = form.querySelector('select[name="language"]');
+  var encrypted = form.querySelector('input[name="encrypted"]');
+
+  function message(text) {
+    var p = document.createElement('p');
+    p.textContent = text;
+    preview.innerHTML = '';
+    preview.appendChild(p);
+  }
+
+  function show(tab) {
+    var buttons = tabs.querySelectorAll('button');
+    for (var i = 0; i < buttons.length; i++) {
+      buttons[i].classList.toggle('active', buttons[i].getAttribute('data-tab') === tab);
+    }
+    textarea.hidden = tab !== 'write';
+    preview.hidden = tab !== 'preview';
+  }
+
+  function render() {
+    // Sending the draft to the server would defeat encrypting it in the
+    // browser, so there's no preview for encrypted snippets.
+    if (encrypted && encrypted.checked) {
+      message('There\'s no preview for snippets encrypted in your browser.');
+      return;
+    }
+    if (language.value !== 'markdown') {
+      message('Choose Markdown as the language to preview the snippet as formatted text.');
+      return;
+    }
+    message('Loading preview...');
+    var body = new URLSearchParams();
+    body.set('content', textarea.value);
+    fetch('/snippet/preview', {method: 'POST', credentials: 'same-origin', body: body})
+      .then(function (response) {
+        if (!response.ok) {
+          throw new Error(response.statusText);
+        }
+        return response.text();
+      })
+      .then(function (html) {
+        preview.innerHTML = html;
+      }, function () {
+        message('The preview could not be loaded.');
+      });
+  }
+
+  tabs.addEventListener('click', function (event) {
+    var tab = event.target.getAttribute('data-tab');
+    if (!tab) {
+      return;
+    }
+    show(tab);
+    if (tab === 'preview') {
+      render();
+    }
+  });
+
+  tabs.hidden = false;
+})();